@@ -7,8 +7,18 @@ import (
 
 // Options is structure, which handles all options, that are used in uciph.
 type Options struct {
-	NonceMode enc.NonceMode
-	RNG       rand.RNG
+	NonceMode           enc.NonceMode
+	RNG                 rand.RNG
+	StreamOptions       enc.StreamOptions
+	ParallelOptions     enc.ParallelOptions
+	AdditionalData      []byte
+	RekeyOptions        enc.RekeyOptions
+	PassphraseOptions   enc.PassphraseOptions
+	InspectOptions      enc.InspectOptions
+	SpoolOptions        enc.SpoolOptions
+	FileOptions         enc.FileOptions
+	CompressionOptions  enc.CompressionOptions
+	ReplayWindowOptions enc.ReplayWindowOptions
 }
 
 func getOpts(o *Options) Options {
@@ -30,16 +40,116 @@ func (o Options) WithNonceMode(nm enc.NonceMode) Options {
 	return no
 }
 
-func (o *Options) GetNonceMode() enc.NonceMode {
+func (o Options) GetNonceMode() enc.NonceMode {
 	if o.NonceMode == 0 {
 		return enc.NonceModeDefault
 	}
 	return o.NonceMode
 }
 
-func (o *Options) GetRNG() rand.RNG {
+func (o Options) GetRNG() rand.RNG {
 	if o.RNG == nil {
 		return rand.DefaultRNG()
 	}
 	return o.RNG
 }
+
+func (o Options) WithStreamOptions(so enc.StreamOptions) Options {
+	no := getOpts(&o)
+	no.StreamOptions = so
+	return no
+}
+
+func (o Options) GetStreamOptions() enc.StreamOptions {
+	return o.StreamOptions
+}
+
+func (o Options) WithParallelOptions(po enc.ParallelOptions) Options {
+	no := getOpts(&o)
+	no.ParallelOptions = po
+	return no
+}
+
+func (o Options) GetParallelOptions() enc.ParallelOptions {
+	return o.ParallelOptions
+}
+
+func (o Options) WithAdditionalData(ad []byte) Options {
+	no := getOpts(&o)
+	no.AdditionalData = ad
+	return no
+}
+
+func (o Options) GetAdditionalData() []byte {
+	return o.AdditionalData
+}
+
+func (o Options) WithRekeyOptions(ro enc.RekeyOptions) Options {
+	no := getOpts(&o)
+	no.RekeyOptions = ro
+	return no
+}
+
+func (o Options) GetRekeyOptions() enc.RekeyOptions {
+	return o.RekeyOptions
+}
+
+func (o Options) WithPassphraseOptions(po enc.PassphraseOptions) Options {
+	no := getOpts(&o)
+	no.PassphraseOptions = po
+	return no
+}
+
+func (o Options) GetPassphraseOptions() enc.PassphraseOptions {
+	return o.PassphraseOptions
+}
+
+func (o Options) WithInspectOptions(io enc.InspectOptions) Options {
+	no := getOpts(&o)
+	no.InspectOptions = io
+	return no
+}
+
+func (o Options) GetInspectOptions() enc.InspectOptions {
+	return o.InspectOptions
+}
+
+func (o Options) WithSpoolOptions(so enc.SpoolOptions) Options {
+	no := getOpts(&o)
+	no.SpoolOptions = so
+	return no
+}
+
+func (o Options) GetSpoolOptions() enc.SpoolOptions {
+	return o.SpoolOptions
+}
+
+func (o Options) WithFileOptions(fo enc.FileOptions) Options {
+	no := getOpts(&o)
+	no.FileOptions = fo
+	return no
+}
+
+func (o Options) GetFileOptions() enc.FileOptions {
+	return o.FileOptions
+}
+
+func (o Options) WithCompressionOptions(co enc.CompressionOptions) Options {
+	no := getOpts(&o)
+	no.CompressionOptions = co
+	return no
+}
+
+func (o Options) GetCompressionOptions() enc.CompressionOptions {
+	return o.CompressionOptions
+}
+
+func (o Options) WithReplayWindowOptions(ro enc.ReplayWindowOptions) Options {
+	no := getOpts(&o)
+	no.ReplayWindowOptions = ro
+	return no
+}
+
+func (o Options) GetReplayWindowOptions() enc.ReplayWindowOptions {
+	return o.ReplayWindowOptions
+}
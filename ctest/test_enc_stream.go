@@ -64,7 +64,10 @@ func DoTestStreamED(
 			t.Error(err)
 			return
 		}
-		testChunks(t, chunks)
+		err = testChunks(t, chunks)
+		if err != nil {
+			t.Error(err)
+		}
 	})
 
 	t.Run("Enc:8MB_1C_Dec:NDEF", func(t *testing.T) {
@@ -73,7 +76,10 @@ func DoTestStreamED(
 			t.Error(err)
 			return
 		}
-		testChunks(t, chunks)
+		err = testChunks(t, chunks)
+		if err != nil {
+			t.Error(err)
+		}
 	})
 
 	t.Run("Enc:8MB_4C_Dec:NDEF", func(t *testing.T) {
@@ -82,7 +88,10 @@ func DoTestStreamED(
 			t.Error(err)
 			return
 		}
-		testChunks(t, chunks)
+		err = testChunks(t, chunks)
+		if err != nil {
+			t.Error(err)
+		}
 	})
 	t.Run("Enc:1KB_1024C_Dec:NDEF", func(t *testing.T) {
 		chunks, err := cbench.MakeTestChunks(rand.DefaultRNG(), cbench.EqualChunkSizes(1024, 1024)...)
@@ -90,7 +99,10 @@ func DoTestStreamED(
 			t.Error(err)
 			return
 		}
-		testChunks(t, chunks)
+		err = testChunks(t, chunks)
+		if err != nil {
+			t.Error(err)
+		}
 	})
 
 	t.Run("CustomTest_1", func(t *testing.T) {
@@ -99,7 +111,10 @@ func DoTestStreamED(
 			t.Error(err)
 			return
 		}
-		testChunks(t, chunks)
+		err = testChunks(t, chunks)
+		if err != nil {
+			t.Error(err)
+		}
 	})
 }
 
@@ -53,3 +53,34 @@ func BenchmarkNonceCounterIncrement(b *testing.B) {
 		})
 	}
 }
+
+func TestNonceCounterSetMatchesIncrement(t *testing.T) {
+	nc := NonceCounter(make([]byte, 12))
+	setNc := NonceCounter(make([]byte, 12))
+	for i := uint64(0); i < 1024; i++ {
+		err := setNc.Set(i)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(nc) != string(setNc) {
+			t.Error(fmt.Sprintf("Set(%d) differs from incremented counter", i))
+			return
+		}
+		err = nc.Increment()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+func TestNonceCounterSetFailsOnOverflow(t *testing.T) {
+	nc := NonceCounter(make([]byte, 2))
+	if err := nc.Set(0xffff); err != nil {
+		t.Error(err)
+	}
+	if err := nc.Set(0x10000); err == nil {
+		t.Error("Expected error but got nil")
+	}
+}
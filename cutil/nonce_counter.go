@@ -46,3 +46,20 @@ func (nonce NonceCounter) Increment() (err error) {
 func (nonce NonceCounter) Len() int {
 	return len(nonce)
 }
+
+// Set assigns value, which NonceCounter would have after n calls to Increment, starting from zero.
+// It's used to derive nonce of n-th chunk without incrementing counter n times.
+// If n does not fit in this counter then error is returned.
+func (nonce NonceCounter) Set(n uint64) (err error) {
+	if len(nonce) < 8 && n>>(8*uint(len(nonce))) != 0 {
+		err = uciph.ErrTooManyChunksEncrypted
+		return
+	}
+
+	for i := 0; i < len(nonce); i++ {
+		nonce[i] = byte(n)
+		n >>= 8
+	}
+
+	return
+}
@@ -62,6 +62,27 @@ type StreamEncryptor interface {
 	io.WriteCloser
 }
 
+// FlushableStreamEncryptor is StreamEncryptor, which is able to write data it buffers before it's closed.
+// Default stream encryptor implements it.
+type FlushableStreamEncryptor interface {
+	StreamEncryptor
+
+	// Flush encrypts and writes all buffered data.
+	Flush() error
+
+	// Sync flushes encryptor and syncs underlying writer, if it's able to.
+	Sync() error
+}
+
+// ResumableStreamEncryptor is FlushableStreamEncryptor, which is able to export its state,
+// so encryption of stream can be resumed with ResumeStreamEncryptor. Default stream encryptor implements it.
+// Encryptor can't be used once its state has been exported.
+type ResumableStreamEncryptor interface {
+	FlushableStreamEncryptor
+
+	ExportState() (StreamEncryptorState, error)
+}
+
 // StreamDecryptor is decryptor, which processes data in streamming manner.
 // StreamDecryptor is guaranteed to find error once some occured.
 // It MAY NOT be able to find out that chunks are reordered or truncated.
@@ -70,3 +91,56 @@ type StreamEncryptor interface {
 type StreamDecryptor interface {
 	io.ReadCloser
 }
+
+// ChunkEncryptor is Encryptor, which is able to encrypt chunk given its index without
+// encrypting chunks before it. Index of chunk is number of Encrypt calls, which would be done before this chunk
+// would be encrypted. Result of EncryptChunk is decryptable in the same way as result of Encrypt.
+//
+// EncryptChunk has to be safe for concurrent use. It's used to encrypt streams in parallel.
+type ChunkEncryptor interface {
+	Encryptor
+
+	// EncryptChunk encrypts chunk with specified index.
+	// It does not modify state used by Encrypt.
+	EncryptChunk(index uint64, in, appendTo []byte) (res []byte, err error)
+}
+
+// RandomNonceEncryptor is Encryptor, which states whether nonce of each encryption is random
+// and stored along with ciphertext. If it is, same chunk may be encrypted again without reusing nonce.
+//
+// Operations, which encrypt already encrypted chunks again, like NewAppendStreamEncryptor,
+// accept only encryptors, which implement it and return true. Wrappers should not implement it,
+// unless they know that wrapped encryptor does.
+type RandomNonceEncryptor interface {
+	Encryptor
+
+	// RandomNonces returns true if each encryption uses new random nonce.
+	RandomNonces() bool
+}
+
+// ChunkDecryptor is Decryptor, which is able to decrypt chunk given its index without
+// decrypting chunks before it.
+// Index of chunk is number of Decrypt calls, which would be done before this chunk would be decrypted.
+//
+// It's used to decrypt streams in random order.
+type ChunkDecryptor interface {
+	Decryptor
+
+	// DecryptChunk decrypts chunk with specified index.
+	// It does not modify state used by Decrypt.
+	DecryptChunk(index uint64, in, appendTo []byte) (res []byte, err error)
+
+	// Overhead returns difference between ciphertext and plaintext sizes.
+	Overhead() int
+}
+
+// SeekableStreamDecryptor is StreamDecryptor, which is able to decrypt any part of stream
+// without decrypting all data before it.
+type SeekableStreamDecryptor interface {
+	StreamDecryptor
+	io.Seeker
+	io.ReaderAt
+
+	// Size returns size of decrypted data.
+	Size() int64
+}
@@ -0,0 +1,17 @@
+package enc
+
+// AdditionalDataOptions specifies options, which provide additional data.
+// AEAD based encryptors authenticate it along with each ciphertext, so ciphertext can be bound to some context,
+// like file path or record ID. Same additional data has to be given to decryptor.
+type AdditionalDataOptions interface {
+	GetAdditionalData() []byte
+}
+
+// GetAdditionalData gets additional data from specified options.
+// If options do not provide any, nil is returned.
+func GetAdditionalData(options interface{}) []byte {
+	if adopts, ok := options.(AdditionalDataOptions); ok {
+		return adopts.GetAdditionalData()
+	}
+	return nil
+}
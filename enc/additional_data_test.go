@@ -0,0 +1,114 @@
+package enc_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/enc"
+)
+
+func TestAdditionalDataIsAuthenticated(t *testing.T) {
+	ek, dk := makeChaCha20Keys(t)
+	for _, nm := range []enc.NonceMode{enc.NonceModeRandom, enc.NonceModeCounter} {
+		opts := copts.Options{}.WithNonceMode(nm).WithAdditionalData([]byte("record-1"))
+		e, err := ek(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext, err := e.Encrypt([]byte("Hello world"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := dk(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := d.Decrypt(ciphertext, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res, []byte("Hello world")) {
+			t.Fatal("Decrypted data differs")
+		}
+
+		d, err = dk(opts.WithAdditionalData([]byte("record-2")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = d.Decrypt(ciphertext, nil)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	}
+}
+
+func TestStreamAdditionalDataIsAuthenticated(t *testing.T) {
+	ek, dk := makeChaCha20Keys(t)
+	opts := copts.Options{}.WithAdditionalData([]byte("tenant-1"))
+	otherOpts := copts.Options{}.WithAdditionalData([]byte("tenant-2"))
+
+	t.Run("Header", func(t *testing.T) {
+		b := bytes.NewBuffer(nil)
+		se, err := enc.NewHeaderStreamEncryptor(ek, enc.CipherChaCha20Poly1305, b, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = se.Write([]byte("Hello world"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = se.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypt := func(opts interface{}) (err error) {
+			sd, err := enc.NewHeaderStreamDecryptor(map[enc.CipherID]enc.DecKey{
+				enc.CipherChaCha20Poly1305: dk,
+			}, bytes.NewReader(b.Bytes()), opts)
+			if err != nil {
+				return
+			}
+			_, err = ioutil.ReadAll(sd)
+			return
+		}
+
+		err = decrypt(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = decrypt(otherOpts)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	})
+
+	t.Run("STREAM", func(t *testing.T) {
+		aead := makeTestAEAD(t, enc.CipherChaCha20Poly1305)
+		b := bytes.NewBuffer(nil)
+		se, err := enc.NewSTREAMEncryptor(aead, b, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = se.Write([]byte("Hello world"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = se.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sd, err := enc.NewSTREAMDecryptor(aead, bytes.NewReader(b.Bytes()), otherOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ioutil.ReadAll(sd)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	})
+}
@@ -3,6 +3,7 @@ package enc
 import (
 	"crypto/cipher"
 	"io"
+	"sync"
 
 	"github.com/teawithsand/uciph"
 	"github.com/teawithsand/uciph/cutil"
@@ -15,6 +16,8 @@ import (
 
 // NewCtrAEADEncryptor wraps any AEAD and uses it to encrypt chunks.
 // It uses nonce coutner to manage nonces.
+// Additional data from options is authenticated along with each chunk.
+// Returned encryptor is ChunkEncryptor.
 func NewCtrAEADEncryptor(aead cipher.AEAD, options interface{}) Encryptor {
 	var nc cutil.NonceCounter
 
@@ -25,21 +28,48 @@ func NewCtrAEADEncryptor(aead cipher.AEAD, options interface{}) Encryptor {
 	if aead.NonceSize() != nc.Len() {
 		panic("uciph/enc: Nonce length mismatch between cipher.AEAD and NonceCounter")
 	}
-	return EncryptorFunc(func(in, appendTo []byte) (res []byte, err error) {
-		if internal.AnyOverlap(in, appendTo) && internal.InexactOverlap(in, appendTo) {
-			appendTo = nil // make it work always, sometimes not in place(?)
-		}
+	return &ctrAEADEncryptor{
+		aead: aead,
+		nc:   nc,
+		ad:   GetAdditionalData(options),
+	}
+}
 
-		defer func() {
-			err = nc.Increment()
-		}()
-		res = aead.Seal(appendTo, nc[:], in, nil)
+type ctrAEADEncryptor struct {
+	aead cipher.AEAD
+	nc   cutil.NonceCounter
+	ad   []byte
+}
+
+func (e *ctrAEADEncryptor) Encrypt(in, appendTo []byte) (res []byte, err error) {
+	if internal.AnyOverlap(in, appendTo) && internal.InexactOverlap(in, appendTo) {
+		appendTo = nil // make it work always, sometimes not in place(?)
+	}
+
+	defer func() {
+		err = e.nc.Increment()
+	}()
+	res = e.aead.Seal(appendTo, e.nc[:], in, e.ad)
+	return
+}
+
+func (e *ctrAEADEncryptor) EncryptChunk(index uint64, in, appendTo []byte) (res []byte, err error) {
+	if internal.AnyOverlap(in, appendTo) && internal.InexactOverlap(in, appendTo) {
+		appendTo = nil
+	}
+
+	nc := cutil.NonceCounter(make([]byte, e.nc.Len()))
+	err = nc.Set(index)
+	if err != nil {
 		return
-	})
+	}
+	res = e.aead.Seal(appendTo, nc[:], in, e.ad)
+	return
 }
 
 // NewCtrAEADDecryptor wraps any AEAD and uses it to decrypt chunks.
 // It uses nonce coutner to manage nonces.
+// Returned decryptor is ChunkDecryptor.
 func NewCtrAEADDecryptor(aead cipher.AEAD, options interface{}) Decryptor {
 	var nc cutil.NonceCounter
 
@@ -50,58 +80,145 @@ func NewCtrAEADDecryptor(aead cipher.AEAD, options interface{}) Decryptor {
 	if aead.NonceSize() != nc.Len() {
 		panic("uciph/enc: Nonce length mismatch between cipher.AEAD and NonceCounter") // err here?
 	}
-	return DecryptorFunc(func(in, appendTo []byte) (res []byte, err error) {
-		if internal.InexactOverlap(in, appendTo) {
-			appendTo = nil // make it work always, sometimes not in place(?)
+	return &ctrAEADDecryptor{
+		aead: aead,
+		nc:   nc,
+		ad:   GetAdditionalData(options),
+	}
+}
+
+type ctrAEADDecryptor struct {
+	aead cipher.AEAD
+	nc   cutil.NonceCounter
+	ad   []byte
+}
+
+func (d *ctrAEADDecryptor) Decrypt(in, appendTo []byte) (res []byte, err error) {
+	if internal.InexactOverlap(in, appendTo) {
+		appendTo = nil // make it work always, sometimes not in place(?)
+	}
+
+	defer func() {
+		if err == nil {
+			// increment only if succeed? Anyhow decryptor should not be reused after failure.
+			err = d.nc.Increment()
 		}
+	}()
+	res, err = d.aead.Open(appendTo, d.nc[:], in, d.ad)
+	return
+}
 
-		defer func() {
-			if err == nil {
-				// increment only if succeed? Anyhow decryptor should not be reused after failure.
-				err = nc.Increment()
-			}
-		}()
-		res, err = aead.Open(appendTo, nc[:], in, nil)
+func (d *ctrAEADDecryptor) DecryptChunk(index uint64, in, appendTo []byte) (res []byte, err error) {
+	if internal.InexactOverlap(in, appendTo) {
+		appendTo = nil
+	}
+
+	nc := cutil.NonceCounter(make([]byte, d.nc.Len()))
+	err = nc.Set(index)
+	if err != nil {
 		return
-	})
+	}
+	res, err = d.aead.Open(appendTo, nc[:], in, d.ad)
+	return
+}
+
+func (d *ctrAEADDecryptor) Overhead() int {
+	return d.aead.Overhead()
 }
 
 // NewRNGAEADEncryptor creates new encryptor, which uses RNG from options to generate
 // nonces.
+// Additional data from options is authenticated along with each chunk.
+// Returned encryptor is ChunkEncryptor. RNG is not used concurrently.
 // Note: It has no limit dependent on nonce length, which may be unsafe sometimes.
 // For instance 12 byte random nonce should not be used more than 2**32 times!
 func NewRNGAEADEncryptor(aead cipher.AEAD, options interface{}) Encryptor {
-	nc := make([]byte, aead.NonceSize())
-	rng := rand.GetRNG(options)
+	return &rngAEADEncryptor{
+		aead: aead,
+		rng:  rand.GetRNG(options),
+		ad:   GetAdditionalData(options),
+	}
+}
 
-	return EncryptorFunc(func(in, appendTo []byte) (res []byte, err error) {
-		_, err = io.ReadFull(rng, nc[:])
-		if err != nil {
-			return
-		}
+type rngAEADEncryptor struct {
+	aead cipher.AEAD
+	ad   []byte
 
-		if internal.InexactOverlap(in, appendTo) {
-			appendTo = nil // make it work always, sometimes not in place(?)
-		}
+	lock sync.Mutex
+	rng  rand.RNG
+}
+
+func (e *rngAEADEncryptor) Encrypt(in, appendTo []byte) (res []byte, err error) {
+	var arr [32]byte
+	var nc []byte
+	if e.aead.NonceSize() <= len(arr) {
+		nc = arr[:e.aead.NonceSize()]
+	} else {
+		nc = make([]byte, e.aead.NonceSize())
+	}
 
-		// This one was prepending version
-		/*
-			appendTo = append(appendTo, nc[:]...)
-			res = aead.Seal(appendTo, nc[:], in, nil)
-			copy(appendTo, nc[:])
-		*/
+	e.lock.Lock()
+	_, err = io.ReadFull(e.rng, nc)
+	e.lock.Unlock()
+	if err != nil {
+		return
+	}
+
+	if internal.InexactOverlap(in, appendTo) {
+		appendTo = nil // make it work always, sometimes not in place(?)
+	}
 
-		// note: nonce size is assumed to be known
-		// so there is no need to write it
+	// This one was prepending version
+	/*
+		appendTo = append(appendTo, nc[:]...)
 		res = aead.Seal(appendTo, nc[:], in, nil)
-		res = append(res, nc[:]...)
-		return
-	})
+		copy(appendTo, nc[:])
+	*/
+
+	// note: nonce size is assumed to be known
+	// so there is no need to write it
+	res = e.aead.Seal(appendTo, nc, in, e.ad)
+	res = append(res, nc...)
+	return
+}
+
+// EncryptChunk makes rngAEADEncryptor ChunkEncryptor.
+// Nonces are random, so index is not needed.
+func (e *rngAEADEncryptor) EncryptChunk(index uint64, in, appendTo []byte) (res []byte, err error) {
+	return e.Encrypt(in, appendTo)
+}
+
+// RandomNonces makes rngAEADEncryptor RandomNonceEncryptor.
+func (e *rngAEADEncryptor) RandomNonces() bool {
+	return true
 }
 
 // NewRNGAEADDecryptor creates new decryptor, which is able to decrypt data encrypted using NewRngAEADEncryptor.
+// Returned decryptor is ChunkDecryptor.
 func NewRNGAEADDecryptor(aead cipher.AEAD, options interface{}) Decryptor {
-	nsz := aead.NonceSize()
+	return &rngAEADDecryptor{
+		aead: aead,
+		ad:   GetAdditionalData(options),
+	}
+}
+
+type rngAEADDecryptor struct {
+	aead cipher.AEAD
+	ad   []byte
+}
+
+// DecryptChunk makes rngAEADDecryptor ChunkDecryptor.
+// Nonces are stored along with chunks, so index is not needed.
+func (d *rngAEADDecryptor) DecryptChunk(index uint64, in, appendTo []byte) (res []byte, err error) {
+	return d.Decrypt(in, appendTo)
+}
+
+func (d *rngAEADDecryptor) Overhead() int {
+	return d.aead.Overhead() + d.aead.NonceSize()
+}
+
+func (d *rngAEADDecryptor) Decrypt(in, appendTo []byte) (res []byte, err error) {
+	nsz := d.aead.NonceSize()
 
 	// This one was prepending version
 	/*
@@ -116,56 +233,54 @@ func NewRNGAEADDecryptor(aead cipher.AEAD, options interface{}) Decryptor {
 		}
 		_ = nonceBuffer
 	*/
-	return DecryptorFunc(func(in, appendTo []byte) (res []byte, err error) {
+	if len(in) < nsz {
+		err = uciph.ErrNonceInvalid
+		return
+	}
+	nonce := in[len(in)-nsz:]
+	in = in[:len(in)-nsz]
+	res, err = d.aead.Open(appendTo, nonce, in, d.ad)
+
+	// This one was prepending version
+	/*
 		if len(in) < nsz {
 			err = uciph.ErrNonceInvalid
 			return
 		}
-		nonce := in[len(in)-nsz:]
-		in = in[:len(in)-nsz]
-		res, err = aead.Open(appendTo, nonce, in, nil)
-
-		// This one was prepending version
-		/*
-			if len(in) < nsz {
-				err = uciph.ErrNonceInvalid
-				return
-			}
 
-			if internal.InexactOverlap(in, appendTo) {
-				appendTo = nil // make it work always, sometimes not in place(?)
-			}
+		if internal.InexactOverlap(in, appendTo) {
+			appendTo = nil // make it work always, sometimes not in place(?)
+		}
 
-			// TODO(teawihtsand): consider appending nonce to the end, since this makes overlapping slice
-			// handling much easier
-			// TODO(teawithsand): eliminate copy to nonceBuffer if slices do not overlap for more cases
-			// TODO(teawithsand): check if this hack really works when slies do and do not overlap
-			// TODO(teawithsand): make it not leave garbage nonce bytes at the beggining, which are not part of res
-			// for most cases it's fine but it leaks something between 12 and 24 bytes of memory
-			//
-			// it works like so:
-			// [1, 2, 3, 4, 5, 6, 7, 8] <- in buffer
-			// then in is curred to [3, 4, 5, 6]
-			// then two bytes are nonce and two are data(in this case)
-			// so in now looks like (DBX are decrypted bytes)
-			// [1, 2, 0, 0, DB1, DB2, 7, 8]
-			// And res pointss to [DB1, DB2]
-			// Is it fine to leave modified bytes in in, which are not part of res?
-			// In fact we could revert them(although it's not easy and requires some hacking since we have to make sure that in == appendTo), but is that required?
-			// Right now I am going to leave it as-is.
-
-			if appendTo == nil {
-				res, err = aead.Open(nil, in[:nsz], in[nsz:], nil)
-			} else {
-				// 1. Copy nonce to buffer
-				copy(nonceBuffer, in[:nsz])
-
-				for i := 0; i < nsz; i++ {
-					appendTo = append(appendTo, 0)
-				}
-				res, err = aead.Open(appendTo[nsz:], nonceBuffer, in[nsz:], nil)
+		// TODO(teawihtsand): consider appending nonce to the end, since this makes overlapping slice
+		// handling much easier
+		// TODO(teawithsand): eliminate copy to nonceBuffer if slices do not overlap for more cases
+		// TODO(teawithsand): check if this hack really works when slies do and do not overlap
+		// TODO(teawithsand): make it not leave garbage nonce bytes at the beggining, which are not part of res
+		// for most cases it's fine but it leaks something between 12 and 24 bytes of memory
+		//
+		// it works like so:
+		// [1, 2, 3, 4, 5, 6, 7, 8] <- in buffer
+		// then in is curred to [3, 4, 5, 6]
+		// then two bytes are nonce and two are data(in this case)
+		// so in now looks like (DBX are decrypted bytes)
+		// [1, 2, 0, 0, DB1, DB2, 7, 8]
+		// And res pointss to [DB1, DB2]
+		// Is it fine to leave modified bytes in in, which are not part of res?
+		// In fact we could revert them(although it's not easy and requires some hacking since we have to make sure that in == appendTo), but is that required?
+		// Right now I am going to leave it as-is.
+
+		if appendTo == nil {
+			res, err = d.aead.Open(nil, in[:nsz], in[nsz:], nil)
+		} else {
+			// 1. Copy nonce to buffer
+			copy(nonceBuffer, in[:nsz])
+
+			for i := 0; i < nsz; i++ {
+				appendTo = append(appendTo, 0)
 			}
-		*/
-		return
-	})
+			res, err = d.aead.Open(appendTo[nsz:], nonceBuffer, in[nsz:], nil)
+		}
+	*/
+	return
 }
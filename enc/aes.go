@@ -7,7 +7,6 @@ import (
 
 	"github.com/teawithsand/uciph"
 	"github.com/teawithsand/uciph/rand"
-	"golang.org/x/crypto/chacha20poly1305"
 )
 
 // AESKeySize denotes AES key size(in bits), which is accepted by this library.
@@ -32,6 +31,11 @@ func (s AESKeySize) Check() (err error) {
 	return
 }
 
+// Bytes returns size of AES key in bytes.
+func (s AESKeySize) Bytes() int {
+	return int(s) / 8
+}
+
 // AESKeygen generates AES key.
 func AESKeygen(options interface{}, size AESKeySize, dst []byte) (res []byte, err error) {
 	err = size.Check()
@@ -40,12 +44,12 @@ func AESKeygen(options interface{}, size AESKeySize, dst []byte) (res []byte, er
 	}
 
 	rng := rand.GetRNG(options)
-	var key [chacha20poly1305.KeySize]byte
-	_, err = io.ReadFull(rng, key[:])
+	key := make([]byte, size.Bytes())
+	_, err = io.ReadFull(rng, key)
 	if err != nil {
 		return dst, err
 	}
-	res = append(dst, key[:]...)
+	res = append(dst, key...)
 	return
 }
 
@@ -62,6 +66,25 @@ func NewAESKeygen(size AESKeySize) (kg SymmKeygen, err error) {
 	return
 }
 
+// NewAESGCMAEAD creates AES-GCM cipher.AEAD from key with specified size.
+// It can be used with constructors accepting cipher.AEAD, like NewSTREAMEncryptor.
+func NewAESGCMAEAD(key []byte, size AESKeySize) (aead cipher.AEAD, err error) {
+	err = size.Check()
+	if err != nil {
+		return
+	}
+	if len(key) != size.Bytes() {
+		err = uciph.ErrInvalidKeySize
+		return
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
 // TODO(teawithsand): perser factories accepting key size and creating parser
 
 // ParseAESEncKey parses AES encryption key with specified size for encryptors.
@@ -71,21 +94,16 @@ func ParseAESEncKey(key []byte, size AESKeySize) (k EncKey, err error) {
 		return
 	}
 
-	if len(key) != int(size) {
+	if len(key) != size.Bytes() {
 		err = uciph.ErrInvalidKeySize
 		return
 	}
 
-	var cpKey [chacha20poly1305.KeySize]byte
-	copy(cpKey[:], key[:])
+	cpKey := make([]byte, len(key))
+	copy(cpKey, key)
 
 	k = func(options interface{}) (Encryptor, error) {
-		block, err := aes.NewCipher(cpKey[:])
-		if err != nil {
-			return nil, err
-		}
-
-		aead, err := cipher.NewGCM(block)
+		aead, err := NewAESGCMAEAD(cpKey, size)
 		if err != nil {
 			return nil, err
 		}
@@ -112,21 +130,16 @@ func ParseAESDecKey(key []byte, size AESKeySize) (k DecKey, err error) {
 		return
 	}
 
-	if len(key) != int(size) {
+	if len(key) != size.Bytes() {
 		err = uciph.ErrInvalidKeySize
 		return
 	}
 
-	var cpKey [chacha20poly1305.KeySize]byte
-	copy(cpKey[:], key[:])
+	cpKey := make([]byte, len(key))
+	copy(cpKey, key)
 
 	k = func(options interface{}) (Decryptor, error) {
-		block, err := aes.NewCipher(cpKey[:])
-		if err != nil {
-			return nil, err
-		}
-
-		aead, err := cipher.NewGCM(block)
+		aead, err := NewAESGCMAEAD(cpKey, size)
 		if err != nil {
 			return nil, err
 		}
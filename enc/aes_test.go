@@ -24,11 +24,11 @@ func TestAESED(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			ek, err := enc.ParseChaCha20Poly1305EncKey(rawKey)
+			ek, err := enc.ParseAESEncKey(rawKey, ks)
 			if err != nil {
 				t.Error(err)
 			}
-			dk, err := enc.ParseChaCha20Poly1305DecKey(rawKey)
+			dk, err := enc.ParseAESDecKey(rawKey, ks)
 			if err != nil {
 				t.Error(err)
 			}
@@ -0,0 +1,558 @@
+package enc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/pad"
+	"github.com/teawithsand/uciph/rand"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Archive holds many files encrypted with single key.
+// Each file is stored as separate stream, so any file may be decrypted without touching other ones.
+// Index of entries is stored in the same way after all bodies.
+//
+// Archive format is:
+// magic(4 bytes), version(1 byte), cipher(1 byte), archive ID(16 random bytes), bodies, index
+// and index offset(8 byte big endian).
+// Keys of bodies and index are derived with HKDF from archive key, archive ID is used as salt and
+// cipher and entry number are part of info. So body can't be moved to other entry nor to other archive.
+//
+// Each body and index is default stream without chunk lengths, which uses NonceModeCounter, since each of them
+// has its own key. Data is padded with Padmé padding before encryption. So bodies contain no plaintext,
+// boundaries between them can't be found without key and only approximate size of each of them
+// can be learned from index.
+//
+// Index format is:
+// version(1 byte), entry count(uvarint) and entries.
+// Each entry is: path length(uvarint), path, mode(uvarint), size(uvarint), offset(uvarint), encrypted size(uvarint).
+
+var archiveMagic = [4]byte{'U', 'C', 'A', 'R'}
+
+const (
+	archiveVersion      = 1
+	archiveIndexVersion = 1
+
+	archiveIDSize      = 16
+	archiveHeaderSize  = 6 + archiveIDSize
+	archiveTrailerSize = 8
+)
+
+const (
+	archiveEntryInfo = "uciph/enc archive entry"
+	archiveIndexInfo = "uciph/enc archive index"
+)
+
+// ArchiveEntry describes single file or directory in archive.
+type ArchiveEntry struct {
+	Path string // clean, relative, slash separated
+	Mode os.FileMode
+	Size uint64 // size of file
+
+	Offset        int64 // offset of encrypted body from beginning of archive
+	EncryptedSize int64 // zero for directories
+}
+
+// archiveStreamOptions are options of each stream in archive.
+// There are no chunk lengths, so there is no plaintext in bodies.
+var archiveStreamOptions = StreamOptions{
+	NoChunkLengths: true,
+}
+
+// IsDir returns true if entry is directory.
+func (e *ArchiveEntry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+func validArchivePath(p string) bool {
+	return p != "" && p != "." && p != ".." &&
+		path.Clean(p) == p &&
+		!strings.HasPrefix(p, "/") &&
+		!strings.HasPrefix(p, "../") &&
+		!strings.ContainsAny(p, "\\\x00")
+}
+
+func validArchiveMode(mode os.FileMode) bool {
+	return mode.IsDir() || mode.IsRegular()
+}
+
+// archiveAEAD creates AEAD used to encrypt stream of entry with given index or index of archive.
+func archiveAEAD(key, id []byte, c CipherID, info string, index uint64) (aead cipher.AEAD, err error) {
+	if len(key) == 0 {
+		err = uciph.ErrKeyInvalid
+		return
+	}
+	keySize := c.KeySize()
+	if keySize < 0 {
+		err = uciph.ErrCipherNotAllowed
+		return
+	}
+	var arr [9]byte
+	arr[0] = byte(c)
+	binary.BigEndian.PutUint64(arr[1:], index)
+
+	streamKey := make([]byte, keySize)
+	_, err = io.ReadFull(hkdf.New(sha256.New, key, id, append([]byte(info), arr[:]...)), streamKey)
+	if err != nil {
+		return
+	}
+	return c.NewAEAD(streamKey)
+}
+
+// archiveStreamEncryptor creates padded stream, which is used for entry with given index or index of archive.
+func archiveStreamEncryptor(key, id []byte, c CipherID, info string, index uint64, w io.Writer, options interface{}) (se StreamEncryptor, err error) {
+	aead, err := archiveAEAD(key, id, c, info, index)
+	if err != nil {
+		return
+	}
+	se, err = NewDefaultStreamEncryptorWithOptions(NewCtrAEADEncryptor(aead, options), w, archiveStreamOptions)
+	if err != nil {
+		return
+	}
+	return NewPaddingStreamEncryptor(se, pad.PadmeBucket), nil
+}
+
+type countingWriter struct {
+	W io.Writer
+	N int64
+}
+
+func (cw *countingWriter) Write(data []byte) (sz int, err error) {
+	sz, err = cw.W.Write(data)
+	cw.N += int64(sz)
+	return
+}
+
+// ArchiveWriter writes encrypted archive.
+// Entries are added with Create and Mkdir. Index is written on Close.
+type ArchiveWriter struct {
+	key     []byte
+	id      []byte
+	cipher  CipherID
+	w       *countingWriter
+	options interface{}
+
+	entries []ArchiveEntry
+	paths   map[string]struct{}
+	current StreamEncryptor
+	closed  bool
+}
+
+// NewArchiveWriter creates ArchiveWriter, which encrypts entries with keys derived from given key
+// using cipher c. Archive ID is generated with RNG from options. Additional data from options is authenticated
+// along with each chunk, so same one has to be given to OpenArchive.
+// Header is written, when writer is created.
+func NewArchiveWriter(key []byte, c CipherID, w io.Writer, options interface{}) (aw *ArchiveWriter, err error) {
+	if len(key) == 0 {
+		err = uciph.ErrKeyInvalid
+		return
+	}
+	if c.KeySize() < 0 {
+		err = uciph.ErrCipherNotAllowed
+		return
+	}
+	header := make([]byte, archiveHeaderSize)
+	copy(header[:4], archiveMagic[:])
+	header[4] = archiveVersion
+	header[5] = byte(c)
+	_, err = io.ReadFull(rand.GetRNG(options), header[6:])
+	if err != nil {
+		return
+	}
+
+	cw := &countingWriter{W: w}
+	_, err = cw.Write(header)
+	if err != nil {
+		return
+	}
+
+	aw = &ArchiveWriter{
+		key:     key,
+		id:      header[6:],
+		cipher:  c,
+		w:       cw,
+		options: options,
+		paths:   make(map[string]struct{}),
+	}
+	return
+}
+
+func (aw *ArchiveWriter) finishEntry() (err error) {
+	if aw.current == nil {
+		return
+	}
+	err = aw.current.Close()
+	aw.current = nil
+	if err != nil {
+		return
+	}
+	e := &aw.entries[len(aw.entries)-1]
+	e.EncryptedSize = aw.w.N - e.Offset
+	return
+}
+
+func (aw *ArchiveWriter) addEntry(p string, mode os.FileMode) (err error) {
+	if aw.closed {
+		return uciph.ErrArchiveInvalid
+	}
+	if _, ok := aw.paths[p]; ok || !validArchivePath(p) {
+		return uciph.ErrArchivePathInvalid
+	}
+	err = aw.finishEntry()
+	if err != nil {
+		return
+	}
+	aw.paths[p] = struct{}{}
+	aw.entries = append(aw.entries, ArchiveEntry{
+		Path: p,
+		Mode: mode,
+	})
+	return
+}
+
+// Mkdir adds directory entry. It's required only for directories, which would be empty otherwise.
+func (aw *ArchiveWriter) Mkdir(p string, mode os.FileMode) (err error) {
+	return aw.addEntry(p, mode&os.ModePerm|os.ModeDir)
+}
+
+type archiveEntryWriter struct {
+	aw    *ArchiveWriter
+	se    StreamEncryptor
+	entry int
+}
+
+func (w *archiveEntryWriter) Write(data []byte) (sz int, err error) {
+	if w.aw.current != w.se {
+		return 0, uciph.ErrArchiveInvalid
+	}
+	sz, err = w.se.Write(data)
+	w.aw.entries[w.entry].Size += uint64(sz)
+	return
+}
+
+// Create adds file entry and returns writer for its contents.
+// Writer is valid until next call to Create, Mkdir or Close.
+func (aw *ArchiveWriter) Create(p string, mode os.FileMode) (w io.Writer, err error) {
+	err = aw.addEntry(p, mode&os.ModePerm)
+	if err != nil {
+		return
+	}
+	index := len(aw.entries) - 1
+	aw.entries[index].Offset = aw.w.N
+
+	aw.current, err = archiveStreamEncryptor(aw.key, aw.id, aw.cipher, archiveEntryInfo, uint64(index), aw.w, aw.options)
+	if err != nil {
+		return
+	}
+	w = &archiveEntryWriter{
+		aw:    aw,
+		se:    aw.current,
+		entry: index,
+	}
+	return
+}
+
+// AddDir adds directory tree rooted at dir to archive. Paths of entries are relative to dir.
+// Only directories and regular files are added, other files like symlinks are skipped.
+func (aw *ArchiveWriter) AddDir(dir string) (err error) {
+	return filepath.Walk(dir, func(fp string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fp)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			return aw.Mkdir(rel, info.Mode())
+		} else if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(fp)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w, err := aw.Create(rel, info.Mode())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+func (aw *ArchiveWriter) marshalIndex() []byte {
+	buf := []byte{archiveIndexVersion}
+	buf = appendUvarint(buf, uint64(len(aw.entries)))
+	for _, e := range aw.entries {
+		buf = appendUvarint(buf, uint64(len(e.Path)))
+		buf = append(buf, e.Path...)
+		buf = appendUvarint(buf, uint64(e.Mode))
+		buf = appendUvarint(buf, e.Size)
+		buf = appendUvarint(buf, uint64(e.Offset))
+		buf = appendUvarint(buf, uint64(e.EncryptedSize))
+	}
+	return buf
+}
+
+// Close finishes last entry and writes index. It does not close underlying writer.
+func (aw *ArchiveWriter) Close() (err error) {
+	if aw.closed {
+		return
+	}
+	err = aw.finishEntry()
+	if err != nil {
+		return
+	}
+	aw.closed = true
+
+	indexOffset := aw.w.N
+	se, err := archiveStreamEncryptor(aw.key, aw.id, aw.cipher, archiveIndexInfo, 0, aw.w, aw.options)
+	if err != nil {
+		return
+	}
+	_, err = se.Write(aw.marshalIndex())
+	if err != nil {
+		return
+	}
+	err = se.Close()
+	if err != nil {
+		return
+	}
+
+	var trailer [archiveTrailerSize]byte
+	binary.BigEndian.PutUint64(trailer[:], uint64(indexOffset))
+	_, err = aw.w.Write(trailer[:])
+	return
+}
+
+// ArchiveReader reads archive created with ArchiveWriter.
+// Index is decrypted and verified, when reader is created. Entries are decrypted on demand.
+type ArchiveReader struct {
+	// Entries in order they were added.
+	Entries []ArchiveEntry
+
+	key     []byte
+	id      []byte
+	cipher  CipherID
+	r       io.ReaderAt
+	options interface{}
+}
+
+func (ar *ArchiveReader) parseIndex(data []byte, indexOffset int64) (err error) {
+	br := bytes.NewReader(data)
+	readUvarint := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		var n uint64
+		n, err = binary.ReadUvarint(br)
+		return n
+	}
+
+	version, err := br.ReadByte()
+	if err != nil || version != archiveIndexVersion {
+		return uciph.ErrArchiveInvalid
+	}
+	count := readUvarint()
+	if err != nil || count > uint64(len(data)) {
+		return uciph.ErrArchiveInvalid
+	}
+
+	paths := make(map[string]struct{}, count)
+	entries := make([]ArchiveEntry, 0, count)
+	for i := uint64(0); i < count; i++ {
+		pathSize := readUvarint()
+		if err != nil || pathSize > uint64(br.Len()) {
+			return uciph.ErrArchiveInvalid
+		}
+		p := make([]byte, pathSize)
+		_, err = io.ReadFull(br, p)
+
+		e := ArchiveEntry{
+			Path:          string(p),
+			Mode:          os.FileMode(readUvarint()),
+			Size:          readUvarint(),
+			Offset:        int64(readUvarint()),
+			EncryptedSize: int64(readUvarint()),
+		}
+		if err != nil {
+			return uciph.ErrArchiveInvalid
+		}
+		if _, ok := paths[e.Path]; ok || !validArchivePath(e.Path) || !validArchiveMode(e.Mode) {
+			return uciph.ErrArchiveInvalid
+		}
+		if !e.IsDir() && (e.Offset < archiveHeaderSize || e.EncryptedSize <= 0 ||
+			e.EncryptedSize > indexOffset-e.Offset) {
+			return uciph.ErrArchiveInvalid
+		}
+		paths[e.Path] = struct{}{}
+		entries = append(entries, e)
+	}
+	if br.Len() != 0 {
+		return uciph.ErrArchiveInvalid
+	}
+	ar.Entries = entries
+	return
+}
+
+func (ar *ArchiveReader) decryptor(info string, index uint64, r io.Reader) (sd StreamDecryptor, err error) {
+	aead, err := archiveAEAD(ar.key, ar.id, ar.cipher, info, index)
+	if err != nil {
+		return
+	}
+	sd, err = NewDefaultStreamDecryptorWithOptions(NewCtrAEADDecryptor(aead, ar.options), r, archiveStreamOptions)
+	if err != nil {
+		return
+	}
+	return NewPaddingStreamDecryptor(sd), nil
+}
+
+// OpenArchive reads and verifies index of archive of given size.
+// Ciphers are ones, which are allowed to be used to encrypt archive. Other ones yield ErrCipherNotAllowed.
+// Wrong key or corrupted index yield error.
+func OpenArchive(key []byte, ciphers []CipherID, r io.ReaderAt, size int64, options interface{}) (ar *ArchiveReader, err error) {
+	if size < archiveHeaderSize+archiveTrailerSize {
+		err = uciph.ErrArchiveInvalid
+		return
+	}
+	var header [archiveHeaderSize]byte
+	_, err = r.ReadAt(header[:], 0)
+	if err != nil {
+		return
+	}
+	var magic [4]byte
+	copy(magic[:], header[:4])
+	if magic != archiveMagic || header[4] != archiveVersion {
+		err = uciph.ErrArchiveInvalid
+		return
+	}
+	c := CipherID(header[5])
+	allowed := false
+	for _, ac := range ciphers {
+		allowed = allowed || ac == c
+	}
+	if !allowed {
+		err = uciph.ErrCipherNotAllowed
+		return
+	}
+
+	var trailer [archiveTrailerSize]byte
+	_, err = r.ReadAt(trailer[:], size-archiveTrailerSize)
+	if err != nil {
+		return
+	}
+	indexOffset := binary.BigEndian.Uint64(trailer[:])
+	if indexOffset < archiveHeaderSize || indexOffset > uint64(size-archiveTrailerSize) {
+		err = uciph.ErrArchiveInvalid
+		return
+	}
+
+	res := &ArchiveReader{
+		key:     key,
+		id:      header[6:],
+		cipher:  c,
+		r:       r,
+		options: options,
+	}
+	indexSize := size - archiveTrailerSize - int64(indexOffset)
+	sd, err := res.decryptor(archiveIndexInfo, 0, bufio.NewReader(io.NewSectionReader(r, int64(indexOffset), indexSize)))
+	if err != nil {
+		return
+	}
+	data, err := ioutil.ReadAll(sd)
+	if err != nil {
+		return
+	}
+	err = sd.Close()
+	if err != nil {
+		return
+	}
+	err = res.parseIndex(data, int64(indexOffset))
+	if err != nil {
+		return
+	}
+	ar = res
+	return
+}
+
+// Lookup returns number of entry with given path or -1 if there is no such entry.
+func (ar *ArchiveReader) Lookup(p string) int {
+	for i, e := range ar.Entries {
+		if e.Path == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// Open creates StreamDecryptor of i-th entry. Only its body is read.
+// Directories yield empty streams. Body, which size differs from one stored in index, yields ErrArchiveInvalid.
+func (ar *ArchiveReader) Open(i int) (sd StreamDecryptor, err error) {
+	if i < 0 || i >= len(ar.Entries) {
+		err = uciph.ErrArchivePathInvalid
+		return
+	}
+	e := &ar.Entries[i]
+	if e.IsDir() {
+		return &archiveDirDecryptor{}, nil
+	}
+	sd, err = ar.decryptor(archiveEntryInfo, uint64(i), bufio.NewReader(io.NewSectionReader(ar.r, e.Offset, e.EncryptedSize)))
+	if err != nil {
+		return
+	}
+	return &archiveEntryDecryptor{
+		Source: sd,
+		Left:   e.Size,
+	}, nil
+}
+
+// archiveEntryDecryptor checks if size of entry matches one stored in index.
+type archiveEntryDecryptor struct {
+	Source StreamDecryptor
+	Left   uint64
+}
+
+func (d *archiveEntryDecryptor) Read(buf []byte) (sz int, err error) {
+	sz, err = d.Source.Read(buf)
+	if uint64(sz) > d.Left {
+		return 0, uciph.ErrArchiveInvalid
+	}
+	d.Left -= uint64(sz)
+	if err == io.EOF && d.Left != 0 {
+		err = uciph.ErrArchiveInvalid
+	}
+	return
+}
+
+func (d *archiveEntryDecryptor) Close() error {
+	return d.Source.Close()
+}
+
+type archiveDirDecryptor struct{}
+
+func (archiveDirDecryptor) Read(buf []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (archiveDirDecryptor) Close() error {
+	return nil
+}
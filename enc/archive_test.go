@@ -0,0 +1,250 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/enc"
+)
+
+var testArchiveCiphers = []enc.CipherID{enc.CipherChaCha20Poly1305}
+
+func readArchiveEntry(ar *enc.ArchiveReader, i int) (res []byte, err error) {
+	sd, err := ar.Open(i)
+	if err != nil {
+		return
+	}
+	res, err = ioutil.ReadAll(sd)
+	if err != nil {
+		return
+	}
+	err = sd.Close()
+	return
+}
+
+func makeTestArchive(t *testing.T, key []byte, files map[string][]byte) []byte {
+	b := bytes.NewBuffer(nil)
+	aw, err := enc.NewArchiveWriter(key, enc.CipherChaCha20Poly1305, b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a", "b", "c"} {
+		w, err := aw.Create(p, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write(files[p])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = aw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestArchiveDir(t *testing.T) {
+	dir := makeTempDir(t)
+	files := map[string][]byte{
+		"top":               []byte("top level file"),
+		"sub/nested":        make([]byte, 3*testChunkSize+10),
+		"sub/deeper/empty":  {},
+		"other/file.txt":    []byte("other"),
+		"other/another.txt": []byte("another"),
+	}
+	for p, data := range files {
+		fp := filepath.Join(dir, filepath.FromSlash(p))
+		err := os.MkdirAll(filepath.Dir(fp), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(fp, data, 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.Mkdir(filepath.Join(dir, "empty_dir"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("archive key")
+	b := bytes.NewBuffer(nil)
+	aw, err := enc.NewArchiveWriter(key, enc.CipherChaCha20Poly1305, b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = aw.AddDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = aw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ar, err := enc.OpenArchive(key, testArchiveCiphers, bytes.NewReader(b.Bytes()), int64(b.Len()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := ar.Lookup("empty_dir"); i < 0 || !ar.Entries[i].IsDir() {
+		t.Fatal("Expected empty_dir directory entry")
+	}
+	for p, data := range files {
+		i := ar.Lookup(p)
+		if i < 0 {
+			t.Fatalf("Entry %s not found", p)
+		}
+		if ar.Entries[i].Size != uint64(len(data)) || ar.Entries[i].Mode.Perm() != 0640 {
+			t.Fatalf("Invalid entry %+v", ar.Entries[i])
+		}
+		res, err := readArchiveEntry(ar, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res, data) {
+			t.Fatalf("Data mismatch for %s", p)
+		}
+	}
+}
+
+func TestArchiveHidesEntrySizes(t *testing.T) {
+	key := []byte("key")
+	archive := makeTestArchive(t, key, map[string][]byte{
+		"a": make([]byte, 1000),
+		"b": make([]byte, 1001),
+		"c": make([]byte, 1010),
+	})
+	ar, err := enc.OpenArchive(key, testArchiveCiphers, bytes.NewReader(archive), int64(len(archive)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ar.Entries[1:] {
+		if e.EncryptedSize != ar.Entries[0].EncryptedSize {
+			t.Fatalf("Expected same encrypted sizes, got %d and %d", e.EncryptedSize, ar.Entries[0].EncryptedSize)
+		}
+	}
+	// bodies contain no stream headers, which would mark their boundaries
+	if bytes.Contains(archive, []byte("UCSH")) {
+		t.Fatal("Expected no plaintext stream headers in archive")
+	}
+}
+
+func TestArchiveCipherNotAllowed(t *testing.T) {
+	archive := makeTestArchive(t, []byte("key"), map[string][]byte{"a": []byte("a")})
+	_, err := enc.OpenArchive([]byte("key"), []enc.CipherID{enc.CipherAES256GCM}, bytes.NewReader(archive), int64(len(archive)), nil)
+	if !errors.Is(err, uciph.ErrCipherNotAllowed) {
+		t.Fatalf("Expected ErrCipherNotAllowed, got %v", err)
+	}
+}
+
+func TestArchiveWrongKey(t *testing.T) {
+	archive := makeTestArchive(t, []byte("key"), map[string][]byte{"a": []byte("a")})
+	_, err := enc.OpenArchive([]byte("other key"), testArchiveCiphers, bytes.NewReader(archive), int64(len(archive)), nil)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestArchiveCorruptedEntry(t *testing.T) {
+	key := []byte("key")
+	files := map[string][]byte{
+		"a": []byte("first"),
+		"b": []byte("second"),
+		"c": []byte("third"),
+	}
+	archive := makeTestArchive(t, key, files)
+
+	ar, err := enc.OpenArchive(key, testArchiveCiphers, bytes.NewReader(archive), int64(len(archive)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := ar.Entries[ar.Lookup("b")]
+	archive[e.Offset+e.EncryptedSize-2] ^= 1
+
+	ar, err = enc.OpenArchive(key, testArchiveCiphers, bytes.NewReader(archive), int64(len(archive)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readArchiveEntry(ar, ar.Lookup("b"))
+	if err == nil {
+		t.Fatal("Expected error for corrupted entry")
+	}
+	for _, p := range []string{"a", "c"} {
+		res, err := readArchiveEntry(ar, ar.Lookup(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res, files[p]) {
+			t.Fatal("Data mismatch")
+		}
+	}
+}
+
+func TestArchiveSwappedEntries(t *testing.T) {
+	key := []byte("key")
+	// same sizes, so bodies can be swapped
+	archive := makeTestArchive(t, key, map[string][]byte{
+		"a": []byte("aaaa"),
+		"b": []byte("bbbb"),
+		"c": []byte("cccc"),
+	})
+	ar, err := enc.OpenArchive(key, testArchiveCiphers, bytes.NewReader(archive), int64(len(archive)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ea, eb := ar.Entries[0], ar.Entries[1]
+	if ea.EncryptedSize != eb.EncryptedSize {
+		t.Fatal("Expected same encrypted sizes")
+	}
+	swapped := append([]byte{}, archive...)
+	copy(swapped[ea.Offset:], archive[eb.Offset:eb.Offset+eb.EncryptedSize])
+	copy(swapped[eb.Offset:], archive[ea.Offset:ea.Offset+ea.EncryptedSize])
+
+	ar, err = enc.OpenArchive(key, testArchiveCiphers, bytes.NewReader(swapped), int64(len(swapped)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readArchiveEntry(ar, 0)
+	if err == nil {
+		t.Fatal("Expected error for swapped entry")
+	}
+}
+
+func TestArchiveTruncated(t *testing.T) {
+	archive := makeTestArchive(t, []byte("key"), map[string][]byte{"a": []byte("a")})
+	for _, sz := range []int{0, 10, len(archive) - 1, len(archive) - 9} {
+		_, err := enc.OpenArchive([]byte("key"), testArchiveCiphers, bytes.NewReader(archive[:sz]), int64(sz), nil)
+		if err == nil {
+			t.Fatalf("Expected error for archive truncated to %d bytes", sz)
+		}
+	}
+}
+
+func TestArchiveInvalidPath(t *testing.T) {
+	aw, err := enc.NewArchiveWriter([]byte("key"), enc.CipherChaCha20Poly1305, ioutil.Discard, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"", ".", "..", "../a", "/a", "a/../b", "a//b", "a/", "a\\b"} {
+		_, err = aw.Create(p, 0600)
+		if !errors.Is(err, uciph.ErrArchivePathInvalid) {
+			t.Fatalf("Expected ErrArchivePathInvalid for %q, got %v", p, err)
+		}
+	}
+	_, err = aw.Create("a", 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = aw.Mkdir("a", 0700)
+	if !errors.Is(err, uciph.ErrArchivePathInvalid) {
+		t.Fatalf("Expected ErrArchivePathInvalid for duplicate path, got %v", err)
+	}
+}
@@ -1,9 +1,17 @@
 package enc
 
-var blankEncryptor = EncryptorFunc(func(in, appendTo []byte) (res []byte, err error) {
+type blankEncryptorImpl struct{}
+
+func (blankEncryptorImpl) Encrypt(in, appendTo []byte) (res []byte, err error) {
 	res = append(appendTo, in...)
 	return
-})
+}
+
+func (e blankEncryptorImpl) EncryptChunk(index uint64, in, appendTo []byte) (res []byte, err error) {
+	return e.Encrypt(in, appendTo)
+}
+
+var blankEncryptor = blankEncryptorImpl{}
 
 // BlankEncryptor is encryptor which is essentially NO-OP.
 // It's NOT SECURE AND SHOULD NOT BE USED IN PRODUCTION. It has been crated for testing purposes.
@@ -11,10 +19,22 @@ func BlankEncryptor() Encryptor {
 	return blankEncryptor
 }
 
-var blankDecryptor = DecryptorFunc(func(in, appendTo []byte) (res []byte, err error) {
+type blankDecryptorImpl struct{}
+
+func (blankDecryptorImpl) Decrypt(in, appendTo []byte) (res []byte, err error) {
 	res = append(appendTo, in...)
 	return
-})
+}
+
+func (d blankDecryptorImpl) DecryptChunk(index uint64, in, appendTo []byte) (res []byte, err error) {
+	return d.Decrypt(in, appendTo)
+}
+
+func (blankDecryptorImpl) Overhead() int {
+	return 0
+}
+
+var blankDecryptor = blankDecryptorImpl{}
 
 // BlankDecryptor is decryptor which is essentially NO-OP.
 // It's NOT SECURE AND SHOULD NOT BE USED IN PRODUCTION. It has been crated for testing purposes.
@@ -1,6 +1,7 @@
 package enc
 
 import (
+	"crypto/cipher"
 	"io"
 
 	"github.com/teawithsand/uciph"
@@ -34,6 +35,24 @@ func XChaCha20Poly1305Keygen(options interface{}, dst []byte) (res []byte, err e
 	return
 }
 
+// NewChaCha20Poly1305AEAD creates ChaCha20Poly1305 cipher.AEAD from key.
+// It can be used with constructors accepting cipher.AEAD, like NewSTREAMEncryptor.
+func NewChaCha20Poly1305AEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, uciph.ErrKeyInvalid
+	}
+	return chacha20poly1305.New(key)
+}
+
+// NewXChaCha20Poly1305AEAD creates XChaCha20Poly1305 cipher.AEAD from key.
+// It can be used with constructors accepting cipher.AEAD, like NewSTREAMEncryptor.
+func NewXChaCha20Poly1305AEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, uciph.ErrKeyInvalid
+	}
+	return chacha20poly1305.NewX(key)
+}
+
 // ParseXChaCha20Poly1305EncKey parses ChaCha20Poly1305 key from bytes for encryption.
 func ParseXChaCha20Poly1305EncKey(key []byte) (EncKey, error) {
 	if len(key) != chacha20poly1305.KeySize {
@@ -45,7 +64,7 @@ func ParseXChaCha20Poly1305EncKey(key []byte) (EncKey, error) {
 
 	return func(options interface{}) (Encryptor, error) {
 		// TODO(teawithsand): add some options here for nonce and other stuff
-		aead, err := chacha20poly1305.NewX(cpKey[:])
+		aead, err := NewXChaCha20Poly1305AEAD(cpKey[:])
 		if err != nil {
 			return nil, err
 		}
@@ -74,7 +93,7 @@ func ParseXChaCha20Poly1305DecKey(key []byte) (DecKey, error) {
 
 	return func(options interface{}) (Decryptor, error) {
 		// TODO(teawithsand): add some options here for nonce and other stuff
-		aead, err := chacha20poly1305.NewX(cpKey[:])
+		aead, err := NewXChaCha20Poly1305AEAD(cpKey[:])
 		if err != nil {
 			return nil, err
 		}
@@ -103,7 +122,7 @@ func ParseChaCha20Poly1305EncKey(key []byte) (EncKey, error) {
 
 	return func(options interface{}) (Encryptor, error) {
 		// TODO(teawithsand): add some options here for nonce and other stuff
-		aead, err := chacha20poly1305.New(cpKey[:])
+		aead, err := NewChaCha20Poly1305AEAD(cpKey[:])
 		if err != nil {
 			return nil, err
 		}
@@ -132,7 +151,7 @@ func ParseChaCha20Poly1305DecKey(key []byte) (DecKey, error) {
 
 	return func(options interface{}) (Decryptor, error) {
 		// TODO(teawithsand): add some options here for nonce and other stuff
-		aead, err := chacha20poly1305.New(cpKey[:])
+		aead, err := NewChaCha20Poly1305AEAD(cpKey[:])
 		if err != nil {
 			return nil, err
 		}
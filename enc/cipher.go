@@ -0,0 +1,114 @@
+package enc
+
+import (
+	"crypto/cipher"
+	"fmt"
+
+	"github.com/teawithsand/uciph"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// CipherID identifies encryption algorithm.
+// It's stored in stream headers, so stream may be decrypted without knowing which algorithm was used.
+type CipherID uint8
+
+const (
+	// CipherChaCha20Poly1305 identifies ChaCha20Poly1305 AEAD.
+	CipherChaCha20Poly1305 CipherID = 1
+	// CipherXChaCha20Poly1305 identifies XChaCha20Poly1305 AEAD.
+	CipherXChaCha20Poly1305 CipherID = 2
+	// CipherAES128GCM identifies AES-GCM AEAD with 128 bit key.
+	CipherAES128GCM CipherID = 3
+	// CipherAES192GCM identifies AES-GCM AEAD with 192 bit key.
+	CipherAES192GCM CipherID = 4
+	// CipherAES256GCM identifies AES-GCM AEAD with 256 bit key.
+	CipherAES256GCM CipherID = 5
+)
+
+func (id CipherID) String() string {
+	switch id {
+	case CipherChaCha20Poly1305:
+		return "ChaCha20Poly1305"
+	case CipherXChaCha20Poly1305:
+		return "XChaCha20Poly1305"
+	case CipherAES128GCM:
+		return "AES128GCM"
+	case CipherAES192GCM:
+		return "AES192GCM"
+	case CipherAES256GCM:
+		return "AES256GCM"
+	default:
+		return fmt.Sprintf("CipherID(%d)", uint8(id))
+	}
+}
+
+// NewAEAD creates cipher.AEAD identified by id from given key.
+func (id CipherID) NewAEAD(key []byte) (cipher.AEAD, error) {
+	switch id {
+	case CipherChaCha20Poly1305:
+		return NewChaCha20Poly1305AEAD(key)
+	case CipherXChaCha20Poly1305:
+		return NewXChaCha20Poly1305AEAD(key)
+	case CipherAES128GCM:
+		return NewAESGCMAEAD(key, AES128)
+	case CipherAES192GCM:
+		return NewAESGCMAEAD(key, AES192)
+	case CipherAES256GCM:
+		return NewAESGCMAEAD(key, AES256)
+	default:
+		return nil, uciph.ErrCipherNotAllowed
+	}
+}
+
+// KeySize returns size of key in bytes, which is used by cipher identified by id.
+// It returns -1 for unknown ciphers.
+func (id CipherID) KeySize() int {
+	switch id {
+	case CipherChaCha20Poly1305, CipherXChaCha20Poly1305:
+		return chacha20poly1305.KeySize
+	case CipherAES128GCM:
+		return AES128.Bytes()
+	case CipherAES192GCM:
+		return AES192.Bytes()
+	case CipherAES256GCM:
+		return AES256.Bytes()
+	default:
+		return -1
+	}
+}
+
+// ParseEncKey parses encryption key for cipher identified by id.
+func (id CipherID) ParseEncKey(key []byte) (EncKey, error) {
+	switch id {
+	case CipherChaCha20Poly1305:
+		return ParseChaCha20Poly1305EncKey(key)
+	case CipherXChaCha20Poly1305:
+		return ParseXChaCha20Poly1305EncKey(key)
+	case CipherAES128GCM:
+		return ParseAESEncKey(key, AES128)
+	case CipherAES192GCM:
+		return ParseAESEncKey(key, AES192)
+	case CipherAES256GCM:
+		return ParseAESEncKey(key, AES256)
+	default:
+		return nil, uciph.ErrCipherNotAllowed
+	}
+}
+
+// ParseDecKey parses decryption key for cipher identified by id.
+func (id CipherID) ParseDecKey(key []byte) (DecKey, error) {
+	switch id {
+	case CipherChaCha20Poly1305:
+		return ParseChaCha20Poly1305DecKey(key)
+	case CipherXChaCha20Poly1305:
+		return ParseXChaCha20Poly1305DecKey(key)
+	case CipherAES128GCM:
+		return ParseAESDecKey(key, AES128)
+	case CipherAES192GCM:
+		return ParseAESDecKey(key, AES192)
+	case CipherAES256GCM:
+		return ParseAESDecKey(key, AES256)
+	default:
+		return nil, uciph.ErrCipherNotAllowed
+	}
+}
@@ -0,0 +1,317 @@
+package enc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+
+	"github.com/teawithsand/uciph"
+)
+
+// Compression before encryption leaks information about plaintext through size of ciphertext.
+// If attacker controls part of plaintext and is able to see sizes, the attacker may be able to guess rest of it,
+// like in CRIME and BREACH attacks. That's why names of compressing functions contain LengthLeaking.
+// Don't use them when plaintext mixes secrets and attacker controlled data.
+//
+// Compressed message is: flag(1 byte) followed by data. It's encrypted, so flag is authenticated.
+// Flag is compressionFlagNone, when data is stored as is or compressionFlagDeflate, when it's compressed with deflate.
+//
+// Compressed stream is always deflate stream. It starts with compressionFlagDeflate byte, which only marks its format.
+
+const (
+	compressionFlagNone    = 0
+	compressionFlagDeflate = 1
+)
+
+// defaultMaxCompressionRatio is default limit of ratio of decompressed size to compressed size.
+// Deflate can't do better than about 1032:1, but typical data is far below 100:1.
+const defaultMaxCompressionRatio = 100
+
+// decompressionAllowance is amount of data, which may always be decompressed regardless of ratio,
+// so small and highly compressible data like short runs of zeros is not rejected.
+const decompressionAllowance = 64 * 1024
+
+// CompressionOptions configures compressing encryptors and decompressing decryptors.
+type CompressionOptions struct {
+	// Level is compress/flate level used by encryptors. Zero means flate.DefaultCompression,
+	// so flate.NoCompression can't be set with it. Stored has to be used instead.
+	Level int
+
+	// Stored makes encryptors use flate.NoCompression, which stores data in deflate stored blocks. Level is ignored then.
+	Stored bool
+
+	// MaxRatio is max ratio of decompressed size to compressed size accepted by decryptors.
+	// Data, which would exceed it, yields ErrDecompressionLimit. Zero or less means 100.
+	// First 64 KiB of decompressed data are not limited by it.
+	MaxRatio int
+}
+
+// CompressionOptionsProvider is kind of options, which provides CompressionOptions.
+type CompressionOptionsProvider interface {
+	GetCompressionOptions() CompressionOptions
+}
+
+// GetCompressionOptions gets compression options from specified options.
+// If options do not provide any, zero CompressionOptions are returned.
+func GetCompressionOptions(options interface{}) (co CompressionOptions) {
+	if coopts, ok := options.(CompressionOptionsProvider); ok {
+		co = coopts.GetCompressionOptions()
+	}
+	return
+}
+
+// GetCompressionOptions makes CompressionOptions CompressionOptionsProvider, so they can be used as options.
+func (co CompressionOptions) GetCompressionOptions() CompressionOptions {
+	return co
+}
+
+func (co CompressionOptions) withDefaults() CompressionOptions {
+	if co.Stored {
+		co.Level = flate.NoCompression
+	} else if co.Level == 0 {
+		co.Level = flate.DefaultCompression
+	}
+	if co.MaxRatio <= 0 {
+		co.MaxRatio = defaultMaxCompressionRatio
+	}
+	return co
+}
+
+// NewLengthLeakingCompressingEncryptor wraps encryptor, so it compresses each message with deflate before
+// encrypting it. Message is stored as is, if compression does not make it smaller.
+// Level is taken from CompressionOptions from options.
+//
+// Size of ciphertext depends on contents of plaintext. See note about compression in this file.
+func NewLengthLeakingCompressingEncryptor(e Encryptor, options interface{}) (res Encryptor, err error) {
+	level := GetCompressionOptions(options).withDefaults().Level
+	// check level once, so Encrypt does not fail because of it
+	_, err = flate.NewWriter(ioutil.Discard, level)
+	if err != nil {
+		err = uciph.ErrStreamOptionsInvalid
+		return
+	}
+
+	res = EncryptorFunc(func(in, appendTo []byte) (res []byte, err error) {
+		b := bytes.NewBuffer(make([]byte, 0, len(in)/2+1))
+		b.WriteByte(compressionFlagDeflate)
+		fw, err := flate.NewWriter(b, level)
+		if err != nil {
+			return
+		}
+		_, err = fw.Write(in)
+		if err != nil {
+			return
+		}
+		err = fw.Close()
+		if err != nil {
+			return
+		}
+
+		buf := b.Bytes()
+		if len(buf) > len(in) {
+			// note: in may overlap with appendTo, so it's copied to separate buffer
+			buf = append(buf[:0], compressionFlagNone)
+			buf = append(buf, in...)
+		}
+		return e.Encrypt(buf, appendTo)
+	})
+	return
+}
+
+// inflateError maps errors of corrupted deflate data to ErrCiphertextInvalid.
+func inflateError(err error) error {
+	if _, ok := err.(flate.CorruptInputError); ok || err == io.ErrUnexpectedEOF {
+		return uciph.ErrCiphertextInvalid
+	}
+	return err
+}
+
+// inflate decompresses data and appends it to appendTo.
+// Output is limited to maxRatio times size of input plus decompressionAllowance.
+func inflate(data []byte, maxRatio int, appendTo []byte) (res []byte, err error) {
+	limit := int64(len(data))*int64(maxRatio) + decompressionAllowance
+	b := bytes.NewBuffer(appendTo)
+	fr := flate.NewReader(bytes.NewReader(data))
+	defer fr.Close()
+
+	sz, err := io.Copy(b, io.LimitReader(fr, limit+1))
+	if err != nil {
+		err = inflateError(err)
+		return
+	}
+	if sz > limit {
+		err = uciph.ErrDecompressionLimit
+		return
+	}
+	res = b.Bytes()
+	return
+}
+
+// NewDecompressingDecryptor wraps decryptor, so it decompresses messages created with
+// NewLengthLeakingCompressingEncryptor. MaxRatio is taken from CompressionOptions from options.
+func NewDecompressingDecryptor(d Decryptor, options interface{}) Decryptor {
+	maxRatio := GetCompressionOptions(options).withDefaults().MaxRatio
+	return DecryptorFunc(func(in, appendTo []byte) (res []byte, err error) {
+		buf, err := d.Decrypt(in, nil)
+		if err != nil {
+			return
+		}
+		if len(buf) == 0 {
+			err = uciph.ErrCiphertextInvalid
+			return
+		}
+
+		switch buf[0] {
+		case compressionFlagNone:
+			res = append(appendTo, buf[1:]...)
+		case compressionFlagDeflate:
+			res, err = inflate(buf[1:], maxRatio, appendTo)
+		default:
+			err = uciph.ErrCiphertextInvalid
+		}
+		return
+	})
+}
+
+type compressingStreamEncryptor struct {
+	Sink   StreamEncryptor
+	Writer *flate.Writer
+}
+
+func (cse *compressingStreamEncryptor) Write(data []byte) (sz int, err error) {
+	return cse.Writer.Write(data)
+}
+
+// Close finishes compression and closes underlying encryptor.
+func (cse *compressingStreamEncryptor) Close() (err error) {
+	err = cse.Writer.Close()
+	if err != nil {
+		return
+	}
+	return cse.Sink.Close()
+}
+
+// NewLengthLeakingCompressingStreamEncryptor wraps StreamEncryptor, so data written to it is compressed
+// with deflate. Level is taken from CompressionOptions from options.
+//
+// Size of stream depends on contents of data written. See note about compression in this file.
+func NewLengthLeakingCompressingStreamEncryptor(se StreamEncryptor, options interface{}) (res StreamEncryptor, err error) {
+	fw, err := flate.NewWriter(se, GetCompressionOptions(options).withDefaults().Level)
+	if err != nil {
+		err = uciph.ErrStreamOptionsInvalid
+		return
+	}
+	_, err = se.Write([]byte{compressionFlagDeflate})
+	if err != nil {
+		return
+	}
+	res = &compressingStreamEncryptor{
+		Sink:   se,
+		Writer: fw,
+	}
+	return
+}
+
+// countingByteReader counts bytes actually consumed from buffered reader.
+// It implements io.ByteReader, so flate reads from it without its own buffering.
+type countingByteReader struct {
+	R *bufio.Reader
+	N int64
+}
+
+func (cr *countingByteReader) Read(buf []byte) (sz int, err error) {
+	sz, err = cr.R.Read(buf)
+	cr.N += int64(sz)
+	return
+}
+
+func (cr *countingByteReader) ReadByte() (b byte, err error) {
+	b, err = cr.R.ReadByte()
+	if err == nil {
+		cr.N++
+	}
+	return
+}
+
+type decompressingStreamDecryptor struct {
+	Source   StreamDecryptor
+	MaxRatio int64
+
+	Buffered   *bufio.Reader
+	Compressed *countingByteReader
+	Reader     io.Reader // nil until flag is read
+	Written    int64
+
+	ErrorCache error
+}
+
+func (dsd *decompressingStreamDecryptor) init() (err error) {
+	flag, err := dsd.Compressed.ReadByte()
+	if err == io.EOF {
+		err = uciph.ErrCiphertextInvalid
+		return
+	} else if err != nil {
+		return
+	}
+	if flag != compressionFlagDeflate {
+		return uciph.ErrCiphertextInvalid
+	}
+	dsd.Reader = flate.NewReader(dsd.Compressed)
+	return
+}
+
+func (dsd *decompressingStreamDecryptor) Read(buf []byte) (sz int, err error) {
+	if dsd.ErrorCache != nil {
+		return 0, dsd.ErrorCache
+	}
+	defer func() {
+		if err != nil {
+			dsd.ErrorCache = err
+		}
+	}()
+
+	if dsd.Reader == nil {
+		err = dsd.init()
+		if err != nil {
+			return
+		}
+	}
+
+	sz, err = dsd.Reader.Read(buf)
+	dsd.Written += int64(sz)
+	if dsd.Written > dsd.MaxRatio*dsd.Compressed.N+decompressionAllowance {
+		return 0, uciph.ErrDecompressionLimit
+	}
+	if err == io.EOF {
+		// there must be no data after end of deflate stream
+		_, rerr := dsd.Buffered.ReadByte()
+		if rerr == nil {
+			err = uciph.ErrCiphertextInvalid
+		} else if rerr != io.EOF {
+			err = rerr
+		}
+	} else if err != nil {
+		err = inflateError(err)
+	}
+	return
+}
+
+func (dsd *decompressingStreamDecryptor) Close() error {
+	return dsd.Source.Close()
+}
+
+// NewDecompressingStreamDecryptor wraps StreamDecryptor, so it decompresses stream created with
+// NewLengthLeakingCompressingStreamEncryptor. MaxRatio is taken from CompressionOptions from options.
+// It's enforced as data is read against count of compressed bytes consumed so far,
+// so decryptor stops before too much data is decompressed.
+func NewDecompressingStreamDecryptor(sd StreamDecryptor, options interface{}) StreamDecryptor {
+	br := bufio.NewReader(sd)
+	return &decompressingStreamDecryptor{
+		Source:     sd,
+		MaxRatio:   int64(GetCompressionOptions(options).withDefaults().MaxRatio),
+		Buffered:   br,
+		Compressed: &countingByteReader{R: br},
+	}
+}
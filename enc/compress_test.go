@@ -0,0 +1,224 @@
+package enc_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/enc"
+	"github.com/teawithsand/uciph/rand"
+)
+
+func makeCompressingEncryptor(t *testing.T, e enc.Encryptor, options interface{}) enc.Encryptor {
+	ce, err := enc.NewLengthLeakingCompressingEncryptor(e, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ce
+}
+
+func TestCompressingED(t *testing.T) {
+	for name, co := range map[string]enc.CompressionOptions{
+		"Default":     {},
+		"BestSpeed":   {Level: flate.BestSpeed},
+		"HuffmanOnly": {Level: flate.HuffmanOnly},
+		"Stored":      {Stored: true},
+	} {
+		opts := copts.Options{}.WithCompressionOptions(co)
+		t.Run(name, func(t *testing.T) {
+			ctest.DoTestED(t, func() (enc.Encryptor, enc.Decryptor) {
+				e, d := makeChaCha20ED(t, enc.NonceModeRandom)
+				return makeCompressingEncryptor(t, e, opts), enc.NewDecompressingDecryptor(d, opts)
+			}, ctest.TestEDConfig{
+				IsAEAD: true,
+			})
+		})
+	}
+}
+
+func TestCompressingEncryptorSize(t *testing.T) {
+	e := makeCompressingEncryptor(t, enc.BlankEncryptor(), nil)
+	d := enc.NewDecompressingDecryptor(enc.BlankDecryptor(), nil)
+
+	random := make([]byte, 4096)
+	_, err := io.ReadFull(rand.DefaultRNG(), random)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range [][]byte{{}, make([]byte, 4096), random} {
+		res, err := e.Encrypt(data, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) > len(data)+1 {
+			t.Fatalf("Expected at most one byte of overhead, got %d for %d bytes", len(res)-len(data), len(data))
+		}
+		dec, err := d.Decrypt(res, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dec, data) {
+			t.Fatal("Data mismatch")
+		}
+	}
+
+	res, err := e.Encrypt(make([]byte, 4096), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) > 100 {
+		t.Fatalf("Expected zeros to be compressed, got %d bytes", len(res))
+	}
+}
+
+func TestDecompressingDecryptorLimit(t *testing.T) {
+	e := makeCompressingEncryptor(t, enc.BlankEncryptor(), nil)
+	res, err := e.Encrypt(make([]byte, 1024*1024), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = enc.NewDecompressingDecryptor(enc.BlankDecryptor(), nil).Decrypt(res, nil)
+	if !errors.Is(err, uciph.ErrDecompressionLimit) {
+		t.Fatalf("Expected ErrDecompressionLimit, got %v", err)
+	}
+
+	opts := copts.Options{}.WithCompressionOptions(enc.CompressionOptions{MaxRatio: 2000})
+	_, err = enc.NewDecompressingDecryptor(enc.BlankDecryptor(), opts).Decrypt(res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecompressingDecryptorNegativeRatio(t *testing.T) {
+	data := make([]byte, 256*1024)
+	_, err := io.ReadFull(rand.DefaultRNG(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range data {
+		data[i] = "0123456789abcdef"[data[i]&0xf]
+	}
+
+	res, err := makeCompressingEncryptor(t, enc.BlankEncryptor(), nil).Encrypt(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// negative ratio is same as default one
+	opts := copts.Options{}.WithCompressionOptions(enc.CompressionOptions{MaxRatio: -1})
+	dec, err := enc.NewDecompressingDecryptor(enc.BlankDecryptor(), opts).Decrypt(res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec, data) {
+		t.Fatal("Data mismatch")
+	}
+}
+
+func TestDecompressingDecryptorInvalidFlag(t *testing.T) {
+	for _, data := range [][]byte{{}, {2, 1, 2, 3}, {1, 0xff, 0xff}} {
+		_, err := enc.NewDecompressingDecryptor(enc.BlankDecryptor(), nil).Decrypt(data, nil)
+		if !errors.Is(err, uciph.ErrCiphertextInvalid) {
+			t.Fatalf("Expected ErrCiphertextInvalid, got %v", err)
+		}
+	}
+}
+
+func TestCompressingStreamED(t *testing.T) {
+	ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+		se, err := enc.NewLengthLeakingCompressingStreamEncryptor(enc.NewDefaultStreamEncryptor(enc.BlankEncryptor(), w), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return se
+	}, func(r io.Reader) enc.StreamDecryptor {
+		return enc.NewDecompressingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), r), nil)
+	})
+}
+
+func TestCompressingStreamEncryptorStored(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	opts := copts.Options{}.WithCompressionOptions(enc.CompressionOptions{Stored: true})
+	se, err := enc.NewLengthLeakingCompressingStreamEncryptor(enc.NewDefaultStreamEncryptor(enc.BlankEncryptor(), b), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 64*1024)
+	_, err = se.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() <= len(data) {
+		t.Fatalf("Expected zeros to be stored, got %d bytes", b.Len())
+	}
+
+	sd := enc.NewDecompressingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(b.Bytes())), nil)
+	res, err := ioutil.ReadAll(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, data) {
+		t.Fatal("Decompressed data differs")
+	}
+}
+
+func TestDecompressingStreamDecryptorInvalidFlag(t *testing.T) {
+	for _, data := range [][]byte{{}, {0, 1, 2, 3}, {2, 1, 2, 3}} {
+		b := bytes.NewBuffer(nil)
+		se := enc.NewDefaultStreamEncryptor(enc.BlankEncryptor(), b)
+		_, err := se.Write(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = se.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sd := enc.NewDecompressingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(b.Bytes())), nil)
+		_, err = ioutil.ReadAll(sd)
+		if !errors.Is(err, uciph.ErrCiphertextInvalid) {
+			t.Fatalf("Expected ErrCiphertextInvalid, got %v", err)
+		}
+	}
+}
+
+func TestDecompressingStreamDecryptorLimit(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewLengthLeakingCompressingStreamEncryptor(enc.NewDefaultStreamEncryptor(enc.BlankEncryptor(), b), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(make([]byte, 16*1024*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() > 1024*1024 {
+		t.Fatalf("Expected zeros to be compressed, got %d bytes", b.Len())
+	}
+
+	sd := enc.NewDecompressingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(b.Bytes())), nil)
+	sz, err := io.Copy(ioutil.Discard, sd)
+	if !errors.Is(err, uciph.ErrDecompressionLimit) {
+		t.Fatalf("Expected ErrDecompressionLimit, got %v", err)
+	}
+	if sz > 16*1024*1024/2 {
+		t.Fatalf("Expected decompression to stop early, got %d bytes", sz)
+	}
+}
@@ -0,0 +1,277 @@
+package enc
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/teawithsand/uciph"
+)
+
+// Container is stream, which contains length prefixed metadata block followed by body.
+// Both of them are encrypted with the same StreamEncryptor, so metadata is as protected as body is.
+//
+// Metadata block format is:
+// version(1 byte), entry count(uvarint) and entries sorted by key.
+// Each entry is: key length(uvarint), key, type(1 byte), value length(uvarint), value.
+
+const containerMetadataVersion = 1
+
+// maxContainerMetadataSize limits size of metadata, which is accepted by decryptor,
+// so corrupted length does not cause huge allocation.
+const maxContainerMetadataSize = 1 << 20
+
+// MetadataType is type of value stored in Metadata.
+type MetadataType byte
+
+const (
+	MetadataString MetadataType = 1 + iota // string
+	MetadataBytes                          // []byte
+	MetadataUint                           // uint64
+	MetadataInt                            // int64
+	MetadataTime                           // time.Time
+)
+
+// Well known metadata keys, which describe file.
+const (
+	MetadataName     = "name"  // string, base name of file
+	MetadataMIMEType = "mime"  // string
+	MetadataModTime  = "mtime" // time.Time
+	MetadataMode     = "mode"  // uint64, os.FileMode
+)
+
+// Metadata is typed key-value map stored in container.
+// Values have to be one of: string, []byte, uint64, int64, time.Time.
+type Metadata map[string]interface{}
+
+// FileMetadata creates metadata describing file with given info.
+// Name, modification time and mode are set.
+func FileMetadata(fi os.FileInfo) Metadata {
+	return Metadata{
+		MetadataName:    fi.Name(),
+		MetadataModTime: fi.ModTime(),
+		MetadataMode:    uint64(fi.Mode()),
+	}
+}
+
+// String returns string value of given key or empty string if there is no such value.
+func (md Metadata) String(key string) (s string) {
+	s, _ = md[key].(string)
+	return
+}
+
+// Time returns time value of given key or zero time if there is no such value.
+func (md Metadata) Time(key string) (t time.Time) {
+	t, _ = md[key].(time.Time)
+	return
+}
+
+// Uint returns uint64 value of given key. Second value is false if there is no such value.
+func (md Metadata) Uint(key string) (n uint64, ok bool) {
+	n, ok = md[key].(uint64)
+	return
+}
+
+func appendUvarint(buf []byte, n uint64) []byte {
+	var arr [binary.MaxVarintLen64]byte
+	sz := binary.PutUvarint(arr[:], n)
+	return append(buf, arr[:sz]...)
+}
+
+// MarshalBinary encodes metadata. Entries are sorted, so encoding is deterministic.
+func (md Metadata) MarshalBinary() (data []byte, err error) {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	data = append(data, containerMetadataVersion)
+	data = appendUvarint(data, uint64(len(keys)))
+	for _, k := range keys {
+		var ty MetadataType
+		var value []byte
+		switch v := md[k].(type) {
+		case string:
+			ty, value = MetadataString, []byte(v)
+		case []byte:
+			ty, value = MetadataBytes, v
+		case uint64:
+			ty, value = MetadataUint, make([]byte, 8)
+			binary.BigEndian.PutUint64(value, v)
+		case int64:
+			ty, value = MetadataInt, make([]byte, 8)
+			binary.BigEndian.PutUint64(value, uint64(v))
+		case time.Time:
+			ty = MetadataTime
+			value, err = v.MarshalBinary()
+			if err != nil {
+				return nil, uciph.ErrMetadataInvalid
+			}
+		default:
+			return nil, uciph.ErrMetadataInvalid
+		}
+
+		data = appendUvarint(data, uint64(len(k)))
+		data = append(data, k...)
+		data = append(data, byte(ty))
+		data = appendUvarint(data, uint64(len(value)))
+		data = append(data, value...)
+	}
+	return
+}
+
+// UnmarshalBinary decodes metadata encoded with MarshalBinary.
+// Existing entries are removed.
+func (md *Metadata) UnmarshalBinary(data []byte) (err error) {
+	readUvarint := func() (n uint64) {
+		n, sz := binary.Uvarint(data)
+		if sz <= 0 {
+			err = uciph.ErrMetadataInvalid
+			return 0
+		}
+		data = data[sz:]
+		return
+	}
+	readBytes := func() (b []byte) {
+		n := readUvarint()
+		if err != nil {
+			return
+		}
+		if n > uint64(len(data)) {
+			err = uciph.ErrMetadataInvalid
+			return
+		}
+		b, data = data[:n], data[n:]
+		return
+	}
+
+	if len(data) == 0 || data[0] != containerMetadataVersion {
+		return uciph.ErrMetadataInvalid
+	}
+	data = data[1:]
+
+	count := readUvarint()
+	if err != nil {
+		return
+	}
+	// each entry takes at least 3 bytes: key length, type and value length
+	if count > uint64(len(data))/3 {
+		return uciph.ErrMetadataInvalid
+	}
+
+	res := make(Metadata, int(count))
+	for i := uint64(0); i < count; i++ {
+		key := readBytes()
+		if err != nil {
+			return
+		}
+		if len(data) == 0 {
+			return uciph.ErrMetadataInvalid
+		}
+		ty := MetadataType(data[0])
+		data = data[1:]
+		value := readBytes()
+		if err != nil {
+			return
+		}
+
+		if _, ok := res[string(key)]; ok {
+			return uciph.ErrMetadataInvalid
+		}
+
+		switch ty {
+		case MetadataString:
+			res[string(key)] = string(value)
+		case MetadataBytes:
+			res[string(key)] = append([]byte{}, value...)
+		case MetadataUint, MetadataInt:
+			if len(value) != 8 {
+				return uciph.ErrMetadataInvalid
+			}
+			n := binary.BigEndian.Uint64(value)
+			if ty == MetadataUint {
+				res[string(key)] = n
+			} else {
+				res[string(key)] = int64(n)
+			}
+		case MetadataTime:
+			var t time.Time
+			if t.UnmarshalBinary(value) != nil {
+				return uciph.ErrMetadataInvalid
+			}
+			res[string(key)] = t
+		default:
+			return uciph.ErrMetadataInvalid
+		}
+	}
+	if len(data) != 0 {
+		return uciph.ErrMetadataInvalid
+	}
+
+	*md = res
+	return
+}
+
+// NewContainerEncryptor writes metadata block to given StreamEncryptor and returns it,
+// so body of container can be written to it. It has to be closed as usual.
+//
+// Any StreamEncryptor may be used, for instance one created with NewHeaderStreamEncryptor or NewSubkeyStreamEncryptor.
+func NewContainerEncryptor(se StreamEncryptor, md Metadata) (w StreamEncryptor, err error) {
+	data, err := md.MarshalBinary()
+	if err != nil {
+		return
+	}
+	if len(data) > maxContainerMetadataSize {
+		err = uciph.ErrMetadataInvalid
+		return
+	}
+
+	_, err = se.Write(appendUvarint(nil, uint64(len(data))))
+	if err != nil {
+		return
+	}
+	_, err = se.Write(data)
+	if err != nil {
+		return
+	}
+	w = se
+	return
+}
+
+// NewContainerDecryptor reads metadata block from given StreamDecryptor, which decrypts container
+// created with NewContainerEncryptor. Returned StreamDecryptor reads body of container.
+//
+// Metadata is authenticated by the stream layer, but like any other data read from StreamDecryptor
+// stream is known to be complete only once body is read to the end and decryptor is closed.
+func NewContainerDecryptor(sd StreamDecryptor) (md Metadata, body StreamDecryptor, err error) {
+	sz, err := binary.ReadUvarint(byteReaderExt{R: sd})
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = uciph.ErrMetadataInvalid
+		return
+	} else if err != nil {
+		return
+	}
+	if sz > maxContainerMetadataSize {
+		err = uciph.ErrMetadataInvalid
+		return
+	}
+
+	data := make([]byte, int(sz))
+	_, err = io.ReadFull(sd, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = uciph.ErrMetadataInvalid
+		return
+	} else if err != nil {
+		return
+	}
+
+	err = md.UnmarshalBinary(data)
+	if err != nil {
+		return
+	}
+	body = sd
+	return
+}
@@ -0,0 +1,138 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/enc"
+)
+
+func TestContainerED(t *testing.T) {
+	md := enc.Metadata{
+		enc.MetadataName:     "report.pdf",
+		enc.MetadataMIMEType: "application/pdf",
+		enc.MetadataModTime:  time.Date(2020, 5, 4, 3, 2, 1, 123456789, time.FixedZone("X", 3600)),
+		enc.MetadataMode:     uint64(0640),
+		"owner":              int64(-1),
+		"tag":                []byte{1, 2, 3},
+	}
+	body := make([]byte, 100*1024+3)
+	for i := range body {
+		body[i] = byte(i)
+	}
+
+	ek, dk := makeChaCha20Keys(t)
+	opts := copts.Options{}.WithStreamOptions(enc.StreamOptions{ChunkSize: 1000})
+
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewHeaderStreamEncryptor(ek, enc.CipherChaCha20Poly1305, b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se, err = enc.NewContainerEncryptor(se, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := enc.NewHeaderStreamDecryptor(map[enc.CipherID]enc.DecKey{enc.CipherChaCha20Poly1305: dk}, b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readMd, sd, err := enc.NewContainerDecryptor(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ioutil.ReadAll(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sd.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(res, body) {
+		t.Fatal("Body differs")
+	}
+	if len(readMd) != len(md) ||
+		readMd.String(enc.MetadataName) != "report.pdf" ||
+		readMd.String(enc.MetadataMIMEType) != "application/pdf" ||
+		!readMd.Time(enc.MetadataModTime).Equal(md.Time(enc.MetadataModTime)) ||
+		readMd["owner"] != int64(-1) ||
+		!bytes.Equal(readMd["tag"].([]byte), []byte{1, 2, 3}) {
+		t.Fatalf("Metadata differs: %v", readMd)
+	}
+	if mode, ok := readMd.Uint(enc.MetadataMode); !ok || mode != 0640 {
+		t.Fatal("Mode differs")
+	}
+}
+
+func TestMetadataEmptyKeysAndValues(t *testing.T) {
+	md := enc.Metadata{
+		"":  "",
+		"b": []byte{},
+		"s": "",
+	}
+	data, err := md.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res enc.Metadata
+	err = res.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 || res[""] != "" || res["s"] != "" || len(res["b"].([]byte)) != 0 {
+		t.Fatalf("Invalid metadata: %v", res)
+	}
+}
+
+func TestMetadataRejectsInvalid(t *testing.T) {
+	_, err := enc.Metadata{"x": 1.5}.MarshalBinary()
+	if !errors.Is(err, uciph.ErrMetadataInvalid) {
+		t.Fatalf("Expected ErrMetadataInvalid, got %v", err)
+	}
+
+	data, err := enc.Metadata{"a": "b", "c": uint64(1)}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(data); i++ {
+		var md enc.Metadata
+		err = md.UnmarshalBinary(data[:i])
+		if !errors.Is(err, uciph.ErrMetadataInvalid) {
+			t.Fatalf("Expected ErrMetadataInvalid for truncated metadata, got %v", err)
+		}
+	}
+}
+
+func TestContainerDetectsMissingMetadata(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	se := enc.NewDefaultStreamEncryptor(enc.BlankEncryptor(), b)
+	_, err := se.Write([]byte{100, 1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = enc.NewContainerDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), b))
+	if !errors.Is(err, uciph.ErrMetadataInvalid) {
+		t.Fatalf("Expected ErrMetadataInvalid, got %v", err)
+	}
+}
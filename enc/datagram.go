@@ -0,0 +1,224 @@
+package enc
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"sync"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/cutil"
+	"github.com/teawithsand/uciph/enc/internal"
+)
+
+// Datagram messages may be delivered in any order, so counter used as nonce is stored in each message.
+// It's appended to ciphertext as 8 byte big endian integer. Since it's used as nonce, it's authenticated.
+// Decryptor keeps sliding window of recently seen counters, like DTLS and IPsec do, so each message
+// is accepted at most once.
+
+const datagramCounterSize = 8
+
+// defaultReplayWindowSize is default count of counters remembered by replay window.
+const defaultReplayWindowSize = 1024
+
+// ReplayWindowOptions configures replay window of datagram decryptor.
+type ReplayWindowOptions struct {
+	// Size is count of most recent counters, which are remembered.
+	// Messages with counter older than that are rejected. Zero means 1024.
+	Size int
+}
+
+// ReplayWindowOptionsProvider is kind of options, which provides ReplayWindowOptions.
+type ReplayWindowOptionsProvider interface {
+	GetReplayWindowOptions() ReplayWindowOptions
+}
+
+// GetReplayWindowOptions gets replay window options from specified options.
+// If options do not provide any, zero ReplayWindowOptions are returned.
+func GetReplayWindowOptions(options interface{}) (ro ReplayWindowOptions) {
+	if ropts, ok := options.(ReplayWindowOptionsProvider); ok {
+		ro = ropts.GetReplayWindowOptions()
+	}
+	return
+}
+
+// GetReplayWindowOptions makes ReplayWindowOptions ReplayWindowOptionsProvider, so they can be used as options.
+func (ro ReplayWindowOptions) GetReplayWindowOptions() ReplayWindowOptions {
+	return ro
+}
+
+func (ro ReplayWindowOptions) withDefaults() ReplayWindowOptions {
+	if ro.Size <= 0 {
+		ro.Size = defaultReplayWindowSize
+	}
+	return ro
+}
+
+// NewDatagramAEADEncryptor wraps any AEAD and uses it to encrypt messages, which may be decrypted
+// in any order with NewReplayWindowDecryptor. Nonces come from counter, which is stored in each message.
+// Additional data from options is authenticated along with each message.
+// It's safe for concurrent use.
+func NewDatagramAEADEncryptor(aead cipher.AEAD, options interface{}) Encryptor {
+	return &datagramAEADEncryptor{
+		aead: aead,
+		ad:   GetAdditionalData(options),
+	}
+}
+
+type datagramAEADEncryptor struct {
+	aead cipher.AEAD
+	ad   []byte
+
+	lock    sync.Mutex
+	counter uint64
+	done    bool // all counters have been used
+}
+
+func (e *datagramAEADEncryptor) Encrypt(in, appendTo []byte) (res []byte, err error) {
+	e.lock.Lock()
+	counter := e.counter
+	if e.done {
+		err = uciph.ErrTooManyChunksEncrypted
+	} else {
+		e.counter++
+		e.done = e.counter == 0
+	}
+	e.lock.Unlock()
+	if err != nil {
+		return
+	}
+
+	nc := cutil.NonceCounter(make([]byte, e.aead.NonceSize()))
+	err = nc.Set(counter)
+	if err != nil {
+		return
+	}
+
+	if internal.InexactOverlap(in, appendTo) {
+		appendTo = nil
+	}
+	res = e.aead.Seal(appendTo, nc, in, e.ad)
+
+	var arr [datagramCounterSize]byte
+	binary.BigEndian.PutUint64(arr[:], counter)
+	res = append(res, arr[:]...)
+	return
+}
+
+// replayWindow remembers which of Size most recent counters have been seen.
+// Bit i of Bitmap is set, when counter Highest - i has been seen.
+type replayWindow struct {
+	Size    uint64
+	Bitmap  []uint64
+	Highest uint64
+	Any     bool // any counter has been seen
+}
+
+// check returns ErrReplayDetected if counter has been seen or it's too old.
+func (w *replayWindow) check(counter uint64) error {
+	if !w.Any || counter > w.Highest {
+		return nil
+	}
+	diff := w.Highest - counter
+	if diff >= w.Size || w.Bitmap[diff/64]&(1<<(diff%64)) != 0 {
+		return uciph.ErrReplayDetected
+	}
+	return nil
+}
+
+// mark marks counter as seen. It has to be checked before.
+func (w *replayWindow) mark(counter uint64) {
+	if !w.Any || counter > w.Highest {
+		shift := counter - w.Highest
+		if !w.Any || shift >= w.Size {
+			for i := range w.Bitmap {
+				w.Bitmap[i] = 0
+			}
+		} else {
+			words, bits := int(shift/64), shift%64
+			for i := len(w.Bitmap) - 1; i >= 0; i-- {
+				var v uint64
+				if i-words >= 0 {
+					v = w.Bitmap[i-words] << bits
+					if bits > 0 && i-words-1 >= 0 {
+						v |= w.Bitmap[i-words-1] >> (64 - bits)
+					}
+				}
+				w.Bitmap[i] = v
+			}
+		}
+		w.Highest = counter
+		w.Any = true
+	}
+	diff := w.Highest - counter
+	w.Bitmap[diff/64] |= 1 << (diff % 64)
+}
+
+// NewReplayWindowDecryptor creates decryptor, which decrypts messages created with NewDatagramAEADEncryptor
+// in any order. Each message is accepted at most once. Messages, which have been already decrypted or
+// are older than replay window allows, yield ErrReplayDetected.
+// Window size is taken from ReplayWindowOptions from options.
+//
+// Window is updated only after message has been authenticated. It's safe for concurrent use.
+func NewReplayWindowDecryptor(aead cipher.AEAD, options interface{}) Decryptor {
+	size := GetReplayWindowOptions(options).withDefaults().Size
+	return &replayWindowDecryptor{
+		aead: aead,
+		ad:   GetAdditionalData(options),
+		window: replayWindow{
+			Size:   uint64(size),
+			Bitmap: make([]uint64, (size+63)/64),
+		},
+	}
+}
+
+type replayWindowDecryptor struct {
+	aead cipher.AEAD
+	ad   []byte
+
+	lock   sync.Mutex
+	window replayWindow
+}
+
+func (d *replayWindowDecryptor) Overhead() int {
+	return d.aead.Overhead() + datagramCounterSize
+}
+
+func (d *replayWindowDecryptor) Decrypt(in, appendTo []byte) (res []byte, err error) {
+	if len(in) < datagramCounterSize {
+		err = uciph.ErrNonceInvalid
+		return
+	}
+	counter := binary.BigEndian.Uint64(in[len(in)-datagramCounterSize:])
+	in = in[:len(in)-datagramCounterSize]
+
+	d.lock.Lock()
+	err = d.window.check(counter)
+	d.lock.Unlock()
+	if err != nil {
+		return
+	}
+
+	nc := cutil.NonceCounter(make([]byte, d.aead.NonceSize()))
+	err = nc.Set(counter)
+	if err != nil {
+		err = uciph.ErrNonceInvalid
+		return
+	}
+	if internal.InexactOverlap(in, appendTo) {
+		appendTo = nil
+	}
+	res, err = d.aead.Open(appendTo, nc, in, d.ad)
+	if err != nil {
+		return
+	}
+
+	// message may have been decrypted concurrently, so window is checked again
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	err = d.window.check(counter)
+	if err != nil {
+		return nil, err
+	}
+	d.window.mark(counter)
+	return
+}
@@ -0,0 +1,131 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	mrand "math/rand"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/enc"
+)
+
+func makeDatagramED(t *testing.T, options interface{}) (enc.Encryptor, enc.Decryptor) {
+	aead := makeTestAEAD(t, enc.CipherChaCha20Poly1305)
+	return enc.NewDatagramAEADEncryptor(aead, options), enc.NewReplayWindowDecryptor(aead, options)
+}
+
+func encryptDatagrams(t *testing.T, e enc.Encryptor, n int) (messages [][]byte) {
+	for i := 0; i < n; i++ {
+		msg, err := e.Encrypt([]byte{byte(i), byte(i >> 8)}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, msg)
+	}
+	return
+}
+
+func TestDatagramED(t *testing.T) {
+	ctest.DoTestED(t, func() (enc.Encryptor, enc.Decryptor) {
+		return makeDatagramED(t, nil)
+	}, ctest.TestEDConfig{
+		IsAEAD: true,
+	})
+}
+
+func TestReplayWindowDecryptorOutOfOrder(t *testing.T) {
+	for _, size := range []int{1, 64, 100, 200} {
+		opts := copts.Options{}.WithReplayWindowOptions(enc.ReplayWindowOptions{Size: size})
+		e, d := makeDatagramED(t, opts)
+		messages := encryptDatagrams(t, e, 1000)
+
+		// shuffle messages within blocks, which fit in window
+		order := make([]int, len(messages))
+		for i := range order {
+			order[i] = i
+		}
+		rng := mrand.New(mrand.NewSource(int64(size)))
+		for start := 0; start < len(order); start += size {
+			end := start + size
+			if end > len(order) {
+				end = len(order)
+			}
+			block := order[start:end]
+			rng.Shuffle(len(block), func(i, j int) {
+				block[i], block[j] = block[j], block[i]
+			})
+		}
+
+		for _, i := range order {
+			res, err := d.Decrypt(messages[i], nil)
+			if err != nil {
+				t.Fatalf("Size %d: message %d: %v", size, i, err)
+			}
+			if !bytes.Equal(res, []byte{byte(i), byte(i >> 8)}) {
+				t.Fatal("Data mismatch")
+			}
+		}
+		for _, i := range order {
+			_, err := d.Decrypt(messages[i], nil)
+			if !errors.Is(err, uciph.ErrReplayDetected) {
+				t.Fatalf("Expected ErrReplayDetected, got %v", err)
+			}
+		}
+	}
+}
+
+func TestReplayWindowDecryptorTooOld(t *testing.T) {
+	opts := copts.Options{}.WithReplayWindowOptions(enc.ReplayWindowOptions{Size: 64})
+	e, d := makeDatagramED(t, opts)
+	messages := encryptDatagrams(t, e, 200)
+
+	_, err := d.Decrypt(messages[150], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.Decrypt(messages[150-64], nil)
+	if !errors.Is(err, uciph.ErrReplayDetected) {
+		t.Fatalf("Expected ErrReplayDetected, got %v", err)
+	}
+	_, err = d.Decrypt(messages[150-63], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayWindowDecryptorForgedCounter(t *testing.T) {
+	e, d := makeDatagramED(t, nil)
+	messages := encryptDatagrams(t, e, 3)
+
+	// counter of message 0 changed to 2 does not authenticate and does not mark 2 as seen
+	forged := append([]byte{}, messages[0]...)
+	forged[len(forged)-1] = 2
+	_, err := d.Decrypt(forged, nil)
+	if err == nil || errors.Is(err, uciph.ErrReplayDetected) {
+		t.Fatalf("Expected authentication error, got %v", err)
+	}
+	for _, i := range []int{2, 0, 1} {
+		_, err = d.Decrypt(messages[i], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDatagramEncryptorUsesDistinctNonces(t *testing.T) {
+	e, _ := makeDatagramED(t, nil)
+	a, err := e.Encrypt([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := e.Encrypt([]byte("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, b) {
+		t.Fatal("Expected different ciphertexts of same data")
+	}
+}
@@ -0,0 +1,163 @@
+package enc
+
+import (
+	"io"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/rand"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Envelope stream is: envelope header followed by stream created with NewSubkeyStreamEncryptor.
+// Random data key is used as master key of that stream. It's wrapped with key encryption key(KEK)
+// using XChaCha20Poly1305 with random nonce.
+//
+// Header format is:
+// magic(4 bytes), version(1 byte), nonce(24 bytes) and wrapped data key(48 bytes).
+// Magic and version are used as additional data.
+//
+// Header has constant size and body does not depend on KEK, so KEK may be changed by rewriting header in place.
+
+var envelopeHeaderMagic = [4]byte{'U', 'C', 'E', 'H'}
+
+const envelopeHeaderVersion = 1
+
+const (
+	// DataKeySize is size of random key used to encrypt body of envelope stream.
+	DataKeySize = 32
+
+	// KEKSize is size of key encryption key used by envelope stream.
+	KEKSize = chacha20poly1305.KeySize
+
+	// EnvelopeHeaderSize is size of header of envelope stream.
+	EnvelopeHeaderSize = 5 + chacha20poly1305.NonceSizeX + DataKeySize + 16 // XChaCha20Poly1305 tag is 16 bytes
+)
+
+func wrapDataKey(kek, dataKey []byte, options interface{}) (header []byte, err error) {
+	aead, err := NewXChaCha20Poly1305AEAD(kek)
+	if err != nil {
+		err = uciph.ErrKeyInvalid
+		return
+	}
+
+	header = make([]byte, 5+chacha20poly1305.NonceSizeX, EnvelopeHeaderSize)
+	copy(header[:4], envelopeHeaderMagic[:])
+	header[4] = envelopeHeaderVersion
+	nonce := header[5:]
+	_, err = io.ReadFull(rand.GetRNG(options), nonce)
+	if err != nil {
+		return
+	}
+	header = aead.Seal(header, nonce, dataKey, header[:5])
+	return
+}
+
+func unwrapDataKey(kek, header []byte) (dataKey []byte, err error) {
+	if len(header) != EnvelopeHeaderSize {
+		err = uciph.ErrStreamHeaderInvalid
+		return
+	}
+	var magic [4]byte
+	copy(magic[:], header[:4])
+	if magic != envelopeHeaderMagic || header[4] != envelopeHeaderVersion {
+		err = uciph.ErrStreamHeaderInvalid
+		return
+	}
+
+	aead, err := NewXChaCha20Poly1305AEAD(kek)
+	if err != nil {
+		err = uciph.ErrKeyInvalid
+		return
+	}
+	nonce := header[5 : 5+chacha20poly1305.NonceSizeX]
+	dataKey, err = aead.Open(nil, nonce, header[5+chacha20poly1305.NonceSizeX:], header[:5])
+	if err != nil {
+		err = uciph.ErrKeyInvalid
+	}
+	return
+}
+
+func readEnvelopeHeader(r io.Reader) (header []byte, err error) {
+	header = make([]byte, EnvelopeHeaderSize)
+	_, err = io.ReadFull(r, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = uciph.ErrStreamHeaderInvalid
+	}
+	return
+}
+
+// RewrapEnvelopeHeader unwraps data key from envelope header with old KEK and wraps it with new one.
+// Returned header has same size as given one, so it may replace it in place.
+//
+// Wrong old KEK yields ErrKeyInvalid. Nonce is generated with RNG from options.
+func RewrapEnvelopeHeader(oldKEK, newKEK, header []byte, options interface{}) (res []byte, err error) {
+	dataKey, err := unwrapDataKey(oldKEK, header)
+	if err != nil {
+		return
+	}
+	return wrapDataKey(newKEK, dataKey, options)
+}
+
+// RewrapEnvelopeStream changes KEK of envelope stream, which starts at current position of rws.
+// Only header is rewritten, body is not read. Position of rws is left just after header.
+func RewrapEnvelopeStream(oldKEK, newKEK []byte, rws io.ReadWriteSeeker, options interface{}) (err error) {
+	start, err := rws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	header, err := readEnvelopeHeader(rws)
+	if err != nil {
+		return
+	}
+	header, err = RewrapEnvelopeHeader(oldKEK, newKEK, header, options)
+	if err != nil {
+		return
+	}
+	_, err = rws.Seek(start, io.SeekStart)
+	if err != nil {
+		return
+	}
+	_, err = rws.Write(header)
+	return
+}
+
+// NewEnvelopeStreamEncryptor creates StreamEncryptor, which encrypts stream with random data key
+// wrapped with given KEK. KEK has to be KEKSize bytes long.
+//
+// Data key and nonce are generated with RNG from options. Cipher is used to encrypt body.
+// Header is written, when encryptor is created.
+func NewEnvelopeStreamEncryptor(kek []byte, cipher CipherID, w io.Writer, options interface{}) (se StreamEncryptor, err error) {
+	dataKey := make([]byte, DataKeySize)
+	_, err = io.ReadFull(rand.GetRNG(options), dataKey)
+	if err != nil {
+		return
+	}
+
+	header, err := wrapDataKey(kek, dataKey, options)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(header)
+	if err != nil {
+		return
+	}
+
+	return NewSubkeyStreamEncryptor(dataKey, cipher, w, options)
+}
+
+// NewEnvelopeStreamDecryptor reads envelope header and creates StreamDecryptor for stream
+// created with NewEnvelopeStreamEncryptor. Wrong KEK yields ErrKeyInvalid.
+//
+// Ciphers are ones, which are allowed to be used to encrypt body.
+func NewEnvelopeStreamDecryptor(kek []byte, ciphers []CipherID, r io.Reader, options interface{}) (sd StreamDecryptor, err error) {
+	header, err := readEnvelopeHeader(r)
+	if err != nil {
+		return
+	}
+	dataKey, err := unwrapDataKey(kek, header)
+	if err != nil {
+		return
+	}
+
+	return NewSubkeyStreamDecryptor(sameKeyForCiphers(dataKey, ciphers), r, options)
+}
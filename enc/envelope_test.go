@@ -0,0 +1,155 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/enc"
+	"github.com/teawithsand/uciph/rand"
+)
+
+var testEnvelopeCiphers = []enc.CipherID{enc.CipherChaCha20Poly1305}
+
+func makeKEK(t *testing.T) []byte {
+	kek := make([]byte, enc.KEKSize)
+	_, err := io.ReadFull(rand.DefaultRNG(), kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kek
+}
+
+func decryptEnvelope(kek []byte, r io.Reader) (res []byte, err error) {
+	sd, err := enc.NewEnvelopeStreamDecryptor(kek, testEnvelopeCiphers, r, nil)
+	if err != nil {
+		return
+	}
+	res, err = ioutil.ReadAll(sd)
+	if err != nil {
+		return
+	}
+	err = sd.Close()
+	return
+}
+
+func TestEnvelopeStreamED(t *testing.T) {
+	kek := makeKEK(t)
+	ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+		se, err := enc.NewEnvelopeStreamEncryptor(kek, enc.CipherChaCha20Poly1305, w, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return se
+	}, func(r io.Reader) enc.StreamDecryptor {
+		sd, err := enc.NewEnvelopeStreamDecryptor(kek, testEnvelopeCiphers, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sd
+	})
+}
+
+func TestEnvelopeStreamInvalidKEK(t *testing.T) {
+	_, err := enc.NewEnvelopeStreamEncryptor(make([]byte, 16), enc.CipherChaCha20Poly1305, ioutil.Discard, nil)
+	if !errors.Is(err, uciph.ErrKeyInvalid) {
+		t.Fatalf("Expected ErrKeyInvalid, got %v", err)
+	}
+
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewEnvelopeStreamEncryptor(makeKEK(t), enc.CipherChaCha20Poly1305, b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = enc.NewEnvelopeStreamDecryptor(makeKEK(t), testEnvelopeCiphers, b, nil)
+	if !errors.Is(err, uciph.ErrKeyInvalid) {
+		t.Fatalf("Expected ErrKeyInvalid, got %v", err)
+	}
+}
+
+func TestEnvelopeStreamRewrap(t *testing.T) {
+	oldKEK, newKEK := makeKEK(t), makeKEK(t)
+	data := make([]byte, 100*1024)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	f := makeTempFile(t)
+	prefix := []byte("some header")
+	_, err := f.Write(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se, err := enc.NewEnvelopeStreamEncryptor(oldKEK, enc.CipherChaCha20Poly1305, f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.Seek(int64(len(prefix)), io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.RewrapEnvelopeStream(oldKEK, newKEK, f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodyOffset := len(prefix) + enc.EnvelopeHeaderSize
+	if len(after) != len(before) || !bytes.Equal(after[bodyOffset:], before[bodyOffset:]) || !bytes.Equal(after[:len(prefix)], prefix) {
+		t.Fatal("Expected only header to be rewritten")
+	}
+
+	_, err = decryptEnvelope(oldKEK, bytes.NewReader(after[len(prefix):]))
+	if !errors.Is(err, uciph.ErrKeyInvalid) {
+		t.Fatalf("Expected ErrKeyInvalid for old KEK, got %v", err)
+	}
+	res, err := decryptEnvelope(newKEK, bytes.NewReader(after[len(prefix):]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, data) {
+		t.Fatal("Data mismatch")
+	}
+
+	// rewrapping with wrong key leaves stream untouched
+	_, err = f.Seek(int64(len(prefix)), io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.RewrapEnvelopeStream(oldKEK, makeKEK(t), f, nil)
+	if !errors.Is(err, uciph.ErrKeyInvalid) {
+		t.Fatalf("Expected ErrKeyInvalid, got %v", err)
+	}
+	unchanged, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unchanged, after) {
+		t.Fatal("Expected stream to be untouched")
+	}
+}
@@ -0,0 +1,172 @@
+package enc
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileOptions configures EncryptFile and DecryptFile.
+type FileOptions struct {
+	// Progress, if not nil, is called after each read from source file with number of bytes
+	// read so far and size of source file.
+	Progress func(done, total int64)
+}
+
+// FileOptionsProvider is kind of options, which provides FileOptions.
+type FileOptionsProvider interface {
+	GetFileOptions() FileOptions
+}
+
+// GetFileOptions gets file options from specified options.
+// If options do not provide any, zero FileOptions are returned.
+func GetFileOptions(options interface{}) (fo FileOptions) {
+	if fopts, ok := options.(FileOptionsProvider); ok {
+		fo = fopts.GetFileOptions()
+	}
+	return
+}
+
+// GetFileOptions makes FileOptions FileOptionsProvider, so they can be used as options.
+func (fo FileOptions) GetFileOptions() FileOptions {
+	return fo
+}
+
+// progressReader reads from source file, checks context and reports progress.
+type progressReader struct {
+	Ctx      context.Context
+	R        io.Reader
+	Done     int64
+	Total    int64
+	Progress func(done, total int64)
+}
+
+func (pr *progressReader) Read(buf []byte) (sz int, err error) {
+	err = pr.Ctx.Err()
+	if err != nil {
+		return
+	}
+	sz, err = pr.R.Read(buf)
+	pr.Done += int64(sz)
+	if pr.Progress != nil && sz > 0 {
+		pr.Progress(pr.Done, pr.Total)
+	}
+	return
+}
+
+// processFile writes result of process to temporary sibling of dst and renames it to dst,
+// once process succeeds and data is synced. Temporary file is removed on any error.
+func processFile(ctx context.Context, src, dst string, options interface{}, process func(r io.Reader, w io.Writer) error) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return
+	}
+
+	dir, name := filepath.Split(dst)
+	if dir == "" {
+		dir = "."
+	}
+	out, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+
+	r := &progressReader{
+		Ctx:      ctx,
+		R:        in,
+		Total:    info.Size(),
+		Progress: GetFileOptions(options).Progress,
+	}
+	err = process(r, out)
+	if err != nil {
+		return
+	}
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+
+	err = out.Chmod(info.Mode().Perm())
+	if err != nil {
+		return
+	}
+	err = out.Sync()
+	if err != nil {
+		return
+	}
+	err = out.Close()
+	if err != nil {
+		return
+	}
+	err = os.Rename(out.Name(), dst)
+	if err != nil {
+		return
+	}
+
+	// Make rename durable. Not all platforms support syncing directories, so errors are ignored.
+	if d, derr := os.Open(dir); derr == nil {
+		d.Sync()
+		d.Close()
+	}
+	return
+}
+
+// EncryptFile encrypts file at src into file at dst using default stream encryptor
+// with encryptor created from given key. StreamOptions are taken from options.
+//
+// Result is written to temporary file in the same directory as dst, which is renamed to dst
+// only after stream has been closed and synced, so dst is never left partially written.
+// It has same permissions as src. Cancelling ctx stops encryption and removes temporary file.
+func EncryptFile(ctx context.Context, key EncKey, src, dst string, options interface{}) (err error) {
+	e, err := key(options)
+	if err != nil {
+		return
+	}
+	return processFile(ctx, src, dst, options, func(r io.Reader, w io.Writer) (err error) {
+		se, err := NewDefaultStreamEncryptorWithOptions(e, w, options)
+		if err != nil {
+			return
+		}
+		_, err = io.Copy(se, r)
+		if err != nil {
+			return
+		}
+		return se.Close()
+	})
+}
+
+// DecryptFile decrypts file at src created with EncryptFile into file at dst.
+// Same StreamOptions have to be given in options.
+//
+// Like EncryptFile it writes to temporary file, which is renamed to dst only after whole stream
+// has been decrypted and verified, so truncated or corrupted stream never yields dst.
+func DecryptFile(ctx context.Context, key DecKey, src, dst string, options interface{}) (err error) {
+	d, err := key(options)
+	if err != nil {
+		return
+	}
+	return processFile(ctx, src, dst, options, func(r io.Reader, w io.Writer) (err error) {
+		sd, err := NewDefaultStreamDecryptorWithOptions(d, r, options)
+		if err != nil {
+			return
+		}
+		_, err = io.Copy(w, sd)
+		if err != nil {
+			return
+		}
+		return sd.Close()
+	})
+}
@@ -0,0 +1,175 @@
+package enc_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/enc"
+)
+
+func makeTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "uciph-file-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func listDir(t *testing.T, dir string) (names []string) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return
+}
+
+func TestEncryptDecryptFile(t *testing.T) {
+	ek, dk := makeChaCha20Keys(t)
+	dir := makeTempDir(t)
+	src := filepath.Join(dir, "plain")
+	encrypted := filepath.Join(dir, "encrypted")
+	decrypted := filepath.Join(dir, "decrypted")
+
+	data := make([]byte, 3*testChunkSize+123)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	err := ioutil.WriteFile(src, data, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chmod(src, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last, total int64
+	opts := copts.Options{}.WithFileOptions(enc.FileOptions{
+		Progress: func(done, tot int64) {
+			if done < last {
+				t.Fatal("Progress went back")
+			}
+			last, total = done, tot
+		},
+	})
+	err = enc.EncryptFile(context.Background(), ek, src, encrypted, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != int64(len(data)) || total != int64(len(data)) {
+		t.Fatalf("Expected progress to reach %d, got %d/%d", len(data), last, total)
+	}
+
+	err = enc.DecryptFile(context.Background(), dk, encrypted, decrypted, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ioutil.ReadFile(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, data) {
+		t.Fatal("Data mismatch")
+	}
+
+	for _, name := range []string{encrypted, decrypted} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Fatalf("Expected permissions 0640, got %v", info.Mode().Perm())
+		}
+	}
+	if len(listDir(t, dir)) != 3 {
+		t.Fatalf("Expected no temporary files left, got %v", listDir(t, dir))
+	}
+}
+
+func TestDecryptFileLeavesDstOnError(t *testing.T) {
+	ek, dk := makeChaCha20Keys(t)
+	dir := makeTempDir(t)
+	src := filepath.Join(dir, "plain")
+	encrypted := filepath.Join(dir, "encrypted")
+	dst := filepath.Join(dir, "dst")
+
+	err := ioutil.WriteFile(src, make([]byte, 3*testChunkSize), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.EncryptFile(context.Background(), ek, src, encrypted, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// truncate final chunk
+	data, err := ioutil.ReadFile(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(encrypted, data[:len(data)-100], 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := []byte("old content")
+	err = ioutil.WriteFile(dst, old, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = enc.DecryptFile(context.Background(), dk, encrypted, dst, nil)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	res, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, old) {
+		t.Fatal("Expected dst to be untouched")
+	}
+	if len(listDir(t, dir)) != 3 {
+		t.Fatalf("Expected no temporary files left, got %v", listDir(t, dir))
+	}
+}
+
+func TestEncryptFileCancel(t *testing.T) {
+	ek, _ := makeChaCha20Keys(t)
+	dir := makeTempDir(t)
+	src := filepath.Join(dir, "plain")
+	dst := filepath.Join(dir, "dst")
+
+	err := ioutil.WriteFile(src, make([]byte, 3*testChunkSize), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := copts.Options{}.WithFileOptions(enc.FileOptions{
+		Progress: func(done, total int64) {
+			cancel()
+		},
+	})
+	err = enc.EncryptFile(ctx, ek, src, dst, opts)
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Fatalf("Expected dst not to exist, got %v", err)
+	}
+	if len(listDir(t, dir)) != 1 {
+		t.Fatalf("Expected no temporary files left, got %v", listDir(t, dir))
+	}
+}
@@ -0,0 +1,204 @@
+package enc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/teawithsand/uciph"
+)
+
+// InspectOptions describes stream, which is inspected.
+type InspectOptions struct {
+	// StreamHeader is true if stream starts with StreamHeader, like one created with NewHeaderStreamEncryptor.
+	// StreamOptions are then taken from header.
+	StreamHeader bool
+
+	// KX is true if chunks were encrypted with encryptor created with NewKXEncKey,
+	// so first chunk starts with KX public part prefixed with its length.
+	KX bool
+}
+
+// InspectOptionsProvider is kind of options, which provides InspectOptions.
+type InspectOptionsProvider interface {
+	GetInspectOptions() InspectOptions
+}
+
+// GetInspectOptions gets inspect options from specified options.
+// If options do not provide any, zero InspectOptions are returned.
+func GetInspectOptions(options interface{}) (ins InspectOptions) {
+	if iopts, ok := options.(InspectOptionsProvider); ok {
+		ins = iopts.GetInspectOptions()
+	}
+	return
+}
+
+// GetInspectOptions makes InspectOptions InspectOptionsProvider, so they can be used as options.
+func (ins InspectOptions) GetInspectOptions() InspectOptions {
+	return ins
+}
+
+// ChunkInfo describes single encrypted chunk in stream.
+type ChunkInfo struct {
+	Offset     int64 // offset of chunk's length
+	LengthSize int   // size of chunk's length
+	Size       int64 // size of encrypted chunk, as stored in its length
+}
+
+// StreamInfo describes structure of encrypted stream.
+type StreamInfo struct {
+	Header     *StreamHeader // nil if stream has no header
+	HeaderSize int64
+
+	// KXPublicPart is KX public part stored in first chunk by NewKXEncKey.
+	// KXPublicPartSize is its size as stored in its length prefix. It's -1 if KX was not inspected or not found.
+	KXPublicPart     []byte
+	KXPublicPartSize int64
+
+	Chunks []ChunkInfo
+
+	// Terminator is true if stream contains terminator. TerminatorOffset is its offset then.
+	Terminator       bool
+	TerminatorOffset int64
+
+	// Truncated is true if last chunk is shorter than its length says.
+	Truncated bool
+
+	// TrailingDataSize is count of bytes after terminator, which should not be there.
+	TrailingDataSize int64
+
+	Size int64 // size of whole stream
+}
+
+type countingReader struct {
+	R io.Reader
+	N int64
+}
+
+func (cr *countingReader) Read(buf []byte) (sz int, err error) {
+	sz, err = cr.R.Read(buf)
+	cr.N += int64(sz)
+	return
+}
+
+// InspectStream walks stream created with default stream encryptor and describes its structure.
+// It does not decrypt anything, so no key is needed.
+//
+// InspectOptions are taken from options. StreamOptions are taken from options, unless stream has header.
+// Only streams with chunk lengths can be inspected, since sizes of encrypted chunks are not known otherwise.
+// Info gathered so far is returned along with error, if any.
+func InspectStream(r io.Reader, options interface{}) (info StreamInfo, err error) {
+	info.KXPublicPartSize = -1
+	cr := &countingReader{R: r}
+	br := bufio.NewReader(cr)
+	// offset returns count of bytes consumed from br
+	offset := func() int64 {
+		return cr.N - int64(br.Buffered())
+	}
+	defer func() {
+		if err == nil {
+			// rest of stream is read here, when it's not read yet
+			var n int64
+			n, err = io.Copy(ioutil.Discard, br)
+			info.TrailingDataSize += n
+		}
+		info.Size = offset()
+	}()
+
+	iopts := GetInspectOptions(options)
+	so := GetStreamOptions(options)
+	if iopts.StreamHeader {
+		var h StreamHeader
+		h, err = ReadStreamHeader(br)
+		if err != nil {
+			return
+		}
+		info.Header = &h
+		info.HeaderSize = offset()
+		so = h.StreamOptions
+	}
+	err = so.Validate()
+	if err != nil {
+		return
+	}
+	so = so.withDefaults()
+	if so.NoChunkLengths {
+		err = uciph.ErrStreamOptionsInvalid
+		return
+	}
+	lengthEncoding := so.ChunkLengthEncoding
+
+	for {
+		chunkOffset := offset()
+		var size uint64
+		size, err = lengthEncoding.Decode(br)
+		if err == io.EOF && offset() == chunkOffset {
+			// stream ends without terminator
+			err = nil
+			return
+		} else if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+			info.Truncated = true
+			return
+		} else if err != nil {
+			return
+		}
+
+		if size == 0 {
+			info.Terminator = true
+			info.TerminatorOffset = chunkOffset
+			return
+		}
+
+		ci := ChunkInfo{
+			Offset:     chunkOffset,
+			LengthSize: int(offset() - chunkOffset),
+			Size:       int64(size),
+		}
+		info.Chunks = append(info.Chunks, ci)
+
+		remaining := int64(size)
+		if iopts.KX && len(info.Chunks) == 1 && remaining >= 4 {
+			var arr [4]byte
+			_, err = io.ReadFull(br, arr[:])
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = nil
+				info.Truncated = true
+				return
+			} else if err != nil {
+				return
+			}
+			remaining -= 4
+
+			pkSize := int64(binary.BigEndian.Uint32(arr[:]))
+			info.KXPublicPartSize = pkSize
+			if pkSize <= remaining {
+				info.KXPublicPart = make([]byte, pkSize)
+				_, err = io.ReadFull(br, info.KXPublicPart)
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					err = nil
+					info.KXPublicPart = nil
+					info.Truncated = true
+					return
+				} else if err != nil {
+					return
+				}
+				remaining -= pkSize
+			}
+		}
+
+		var n int64
+		n, err = io.CopyN(ioutil.Discard, br, remaining)
+		if err == io.EOF {
+			err = nil
+			info.Truncated = true
+			return
+		} else if err != nil {
+			return
+		} else if n != remaining {
+			info.Truncated = true
+			return
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package enc_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/enc"
+	"github.com/teawithsand/uciph/kx"
+)
+
+func TestInspectStream(t *testing.T) {
+	const chunkSize = 1000
+	opts := copts.Options{}.WithStreamOptions(enc.StreamOptions{ChunkSize: chunkSize})
+
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewDefaultStreamEncryptorWithOptions(enc.BlankEncryptor(), b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(make([]byte, chunkSize*3+10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := b.Bytes()
+
+	// length(2 bytes) + counter + flag + data
+	frameSize := int64(2 + 1 + 1 + chunkSize)
+
+	info, err := enc.InspectStream(bytes.NewReader(encrypted), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Chunks) != 4 || !info.Terminator || info.Truncated || info.TrailingDataSize != 0 ||
+		info.Header != nil || info.KXPublicPartSize != -1 || info.Size != int64(len(encrypted)) {
+		t.Fatalf("Invalid info: %+v", info)
+	}
+	for i, ci := range info.Chunks[:3] {
+		if ci.Offset != int64(i)*frameSize || ci.LengthSize != 2 || ci.Size != frameSize-2 {
+			t.Fatalf("Invalid chunk info: %+v", ci)
+		}
+	}
+	if info.Chunks[3].Size != 1+1+10 || info.TerminatorOffset != int64(len(encrypted)-1) {
+		t.Fatalf("Invalid info: %+v", info)
+	}
+
+	t.Run("TrailingData", func(t *testing.T) {
+		info, err := enc.InspectStream(bytes.NewReader(append(append([]byte{}, encrypted...), 1, 2, 3)), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.Terminator || info.TrailingDataSize != 3 || info.Size != int64(len(encrypted)+3) {
+			t.Fatalf("Invalid info: %+v", info)
+		}
+	})
+
+	t.Run("NoTerminator", func(t *testing.T) {
+		info, err := enc.InspectStream(bytes.NewReader(encrypted[:frameSize*2]), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(info.Chunks) != 2 || info.Terminator || info.Truncated {
+			t.Fatalf("Invalid info: %+v", info)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		info, err := enc.InspectStream(bytes.NewReader(encrypted[:frameSize*2+10]), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(info.Chunks) != 3 || info.Terminator || !info.Truncated {
+			t.Fatalf("Invalid info: %+v", info)
+		}
+	})
+}
+
+func TestInspectHeaderStream(t *testing.T) {
+	ek, _ := makeChaCha20Keys(t)
+	opts := copts.Options{}.WithStreamOptions(enc.StreamOptions{ChunkSize: 100, ChunkLengthEncoding: enc.Byte4})
+
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewHeaderStreamEncryptor(ek, enc.CipherChaCha20Poly1305, b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(make([]byte, 250))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stream options are taken from header
+	info, err := enc.InspectStream(bytes.NewReader(b.Bytes()), enc.InspectOptions{StreamHeader: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Header == nil || info.Header.Cipher != enc.CipherChaCha20Poly1305 || info.Header.StreamOptions.ChunkSize != 100 {
+		t.Fatalf("Invalid header: %+v", info.Header)
+	}
+	if len(info.Chunks) != 3 || !info.Terminator || info.Chunks[0].Offset != info.HeaderSize || info.Chunks[0].LengthSize != 4 {
+		t.Fatalf("Invalid info: %+v", info)
+	}
+}
+
+func TestInspectKXStream(t *testing.T) {
+	g := &kx.Generated{}
+	err := kx.GenCurve25519(nil, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := enc.NewKXEncKey(kx.GenCurve25519, kx.Curve25519, g.PublicPart, func(options interface{}, kxResult []byte) (enc.Encryptor, error) {
+		ek, err := enc.ParseChaCha20Poly1305EncKey(kxResult)
+		if err != nil {
+			return nil, err
+		}
+		return ek(copts.Options{}.WithNonceMode(enc.NonceModeCounter))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ek(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := bytes.NewBuffer(nil)
+	se := enc.NewDefaultStreamEncryptor(e, b)
+	_, err = se.Write([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := enc.InspectStream(bytes.NewReader(b.Bytes()), enc.InspectOptions{KX: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.KXPublicPartSize != 32 || len(info.KXPublicPart) != 32 || len(info.Chunks) != 1 || !info.Terminator {
+		t.Fatalf("Invalid info: %+v", info)
+	}
+
+	dk, err := enc.NewKXDecKey(kx.Curve25519, g.SecretPart, func(options interface{}, kxResult []byte) (enc.Decryptor, error) {
+		dk, err := enc.ParseChaCha20Poly1305DecKey(kxResult)
+		if err != nil {
+			return nil, err
+		}
+		return dk(copts.Options{}.WithNonceMode(enc.NonceModeCounter))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := dk(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ioutil.ReadAll(enc.NewDefaultStreamDecryptor(d, bytes.NewReader(b.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != "data" {
+		t.Fatalf("Invalid data: %q", res)
+	}
+}
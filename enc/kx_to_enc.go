@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 
 	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/enc/internal"
 	"github.com/teawithsand/uciph/kx"
 )
 
@@ -46,7 +47,11 @@ func NewKXEncKey(
 
 			// first chunk is special - includes KX algorithm public
 			if len(rawPK) > 0 {
-				// TODO(teawithsand): check how appendTo trick works with overlapping slices
+				// in may be encrypted in place, so public part appended below would overwrite it
+				if internal.AnyOverlap(in, appendTo[:cap(appendTo)]) {
+					in = append([]byte(nil), in...)
+				}
+
 				pkLen := len(rawPK)
 
 				// prepend raw KX public + it's length at the beginning
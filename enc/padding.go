@@ -0,0 +1,230 @@
+package enc
+
+import (
+	"crypto/subtle"
+	"io"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/pad"
+)
+
+// paddingMarker is first byte of ISO/IEC 7816-4 padding. It's followed by zeros.
+const paddingMarker = 0x80
+
+// paddedSize returns size, which message of given size is padded to.
+// There is always room for at least padding marker.
+func paddedSize(bucket pad.Bucket, size uint64) uint64 {
+	res := bucket(size + 1)
+	if res < size+1 {
+		res = size + 1
+	}
+	return res
+}
+
+// NewPaddingEncryptor wraps encryptor, so it pads each chunk with ISO/IEC 7816-4 padding
+// to size returned by bucket before encrypting it.
+// It hides exact size of chunk, but size of bucket is still leaked.
+func NewPaddingEncryptor(e Encryptor, bucket pad.Bucket) Encryptor {
+	return EncryptorFunc(func(in, appendTo []byte) (res []byte, err error) {
+		sz := paddedSize(bucket, uint64(len(in)))
+		if sz > uint64(int(^uint(0)>>1)) {
+			err = uciph.ErrChunkTooBig
+			return
+		}
+
+		// note: in may overlap with appendTo, so padded data is kept in separate buffer
+		buf := make([]byte, int(sz))
+		copy(buf, in)
+		buf = pad.IEC78164Padding().Pad(buf, len(in))
+		return e.Encrypt(buf, appendTo)
+	})
+}
+
+// NewPaddingDecryptor wraps decryptor, so it removes padding added by NewPaddingEncryptor.
+// Padding is removed in constant time with pad.IEC78164Padding.
+func NewPaddingDecryptor(d Decryptor) Decryptor {
+	return DecryptorFunc(func(in, appendTo []byte) (res []byte, err error) {
+		prefixSize := len(appendTo)
+		res, err = d.Decrypt(in, appendTo)
+		if err != nil {
+			return
+		}
+
+		sz := pad.IEC78164Padding().Unpad(res[prefixSize:])
+		if sz < 0 {
+			return nil, uciph.ErrCiphertextInvalid
+		}
+		res = res[:prefixSize+sz]
+		return
+	})
+}
+
+type paddingStreamEncryptor struct {
+	Sink   StreamEncryptor
+	Bucket pad.Bucket
+
+	Written uint64
+}
+
+func (pse *paddingStreamEncryptor) Write(data []byte) (sz int, err error) {
+	sz, err = pse.Sink.Write(data)
+	pse.Written += uint64(sz)
+	return
+}
+
+// Close writes padding and closes underlying encryptor.
+func (pse *paddingStreamEncryptor) Close() (err error) {
+	var zeros [4096]byte
+	padding := paddedSize(pse.Bucket, pse.Written) - pse.Written
+
+	_, err = pse.Sink.Write([]byte{paddingMarker})
+	if err != nil {
+		return
+	}
+	padding--
+
+	for padding > 0 {
+		sz := uint64(len(zeros))
+		if padding < sz {
+			sz = padding
+		}
+		_, err = pse.Sink.Write(zeros[:sz])
+		if err != nil {
+			return
+		}
+		padding -= sz
+	}
+
+	return pse.Sink.Close()
+}
+
+type paddingStreamDecryptor struct {
+	Source StreamDecryptor
+	Buffer []byte
+
+	// Held is last non zero byte read so far followed by HeldZeros zeros.
+	// It may be beginning of padding, so it's returned only once some non zero byte is read after it.
+	HasHeld   bool
+	Held      byte
+	HeldZeros uint64
+
+	// FlushByte and FlushZeros are held bytes, which turned out not to be padding.
+	HasFlushByte bool
+	FlushByte    byte
+	FlushZeros   uint64
+	Ready        []byte // data from Buffer, which is not padding
+
+	// SourceError is returned once all data read before it is returned.
+	SourceError error
+	ErrorCache  error
+}
+
+// load reads next part of stream and finds out which part of it may be padding.
+func (psd *paddingStreamDecryptor) load() (err error) {
+	sz, err := psd.Source.Read(psd.Buffer)
+	data := psd.Buffer[:sz]
+
+	// find last non zero byte without branching on data
+	last := -1
+	for i, b := range data {
+		last = subtle.ConstantTimeSelect(subtle.ConstantTimeByteEq(b, 0), last, i)
+	}
+
+	if last < 0 {
+		psd.HeldZeros += uint64(sz)
+	} else {
+		psd.HasFlushByte = psd.HasHeld
+		psd.FlushByte = psd.Held
+		psd.FlushZeros = psd.HeldZeros
+		psd.Ready = data[:last]
+
+		psd.HasHeld = true
+		psd.Held = data[last]
+		psd.HeldZeros = uint64(sz - last - 1)
+	}
+
+	if err == io.EOF {
+		// Everything held is padding, which has to start with marker
+		if subtle.ConstantTimeByteEq(psd.Held, paddingMarker)&subtle.ConstantTimeEq(boolToInt32(psd.HasHeld), 1) != 1 {
+			err = uciph.ErrCiphertextInvalid
+		}
+	}
+	return
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (psd *paddingStreamDecryptor) Read(buf []byte) (sz int, err error) {
+	if psd.ErrorCache != nil {
+		return 0, psd.ErrorCache
+	}
+
+	for len(buf) > 0 {
+		if psd.HasFlushByte {
+			buf[0] = psd.FlushByte
+			psd.HasFlushByte = false
+			buf = buf[1:]
+			sz++
+		} else if psd.FlushZeros > 0 {
+			n := len(buf)
+			if uint64(n) > psd.FlushZeros {
+				n = int(psd.FlushZeros)
+			}
+			for i := range buf[:n] {
+				buf[i] = 0
+			}
+			psd.FlushZeros -= uint64(n)
+			buf = buf[n:]
+			sz += n
+		} else if len(psd.Ready) > 0 {
+			n := copy(buf, psd.Ready)
+			psd.Ready = psd.Ready[n:]
+			buf = buf[n:]
+			sz += n
+		} else if psd.SourceError != nil {
+			// Data read before error is returned first
+			if sz == 0 {
+				psd.ErrorCache = psd.SourceError
+				err = psd.ErrorCache
+			}
+			return
+		} else {
+			psd.SourceError = psd.load()
+		}
+	}
+	return
+}
+
+func (psd *paddingStreamDecryptor) Close() (err error) {
+	err = psd.Source.Close()
+	if psd.ErrorCache != nil && psd.ErrorCache != io.EOF {
+		err = psd.ErrorCache
+	}
+	return
+}
+
+// NewPaddingStreamEncryptor wraps StreamEncryptor, so it pads whole stream with ISO/IEC 7816-4 padding
+// to size returned by bucket. Padding is written on close.
+// Since padding is encrypted, size of encrypted stream leaks only size of bucket.
+func NewPaddingStreamEncryptor(se StreamEncryptor, bucket pad.Bucket) StreamEncryptor {
+	return &paddingStreamEncryptor{
+		Sink:   se,
+		Bucket: bucket,
+	}
+}
+
+// NewPaddingStreamDecryptor wraps StreamDecryptor, so it removes padding added by NewPaddingStreamEncryptor.
+//
+// Padding is found without branching on contents of data. Trailing zeros are held as a counter,
+// so memory usage does not depend on size of padding.
+func NewPaddingStreamDecryptor(sd StreamDecryptor) StreamDecryptor {
+	return &paddingStreamDecryptor{
+		Source: sd,
+		Buffer: make([]byte, 32*1024),
+	}
+}
@@ -0,0 +1,107 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/enc"
+	"github.com/teawithsand/uciph/pad"
+)
+
+func TestPaddingED(t *testing.T) {
+	for name, bucket := range map[string]pad.Bucket{
+		"Padme":      pad.PadmeBucket,
+		"PowerOfTwo": pad.PowerOfTwoBucket,
+		"Block":      pad.BlockBucket(64),
+	} {
+		bucket := bucket
+		t.Run(name, func(t *testing.T) {
+			ctest.DoTestED(t, func() (enc.Encryptor, enc.Decryptor) {
+				e, d := makeChaCha20ED(t, enc.NonceModeRandom)
+				return enc.NewPaddingEncryptor(e, bucket), enc.NewPaddingDecryptor(d)
+			}, ctest.TestEDConfig{
+				IsAEAD: true,
+			})
+		})
+	}
+}
+
+func TestPaddingHidesSize(t *testing.T) {
+	e := enc.NewPaddingEncryptor(enc.BlankEncryptor(), pad.BlockBucket(64))
+	for size := 0; size < 63; size++ {
+		res, err := e.Encrypt(make([]byte, size), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 64 {
+			t.Fatalf("Invalid size of padded %d bytes: %d", size, len(res))
+		}
+	}
+}
+
+func TestPaddingStreamED(t *testing.T) {
+	ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+		return enc.NewPaddingStreamEncryptor(enc.NewDefaultStreamEncryptor(enc.BlankEncryptor(), w), pad.PadmeBucket)
+	}, func(r io.Reader) enc.StreamDecryptor {
+		return enc.NewPaddingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), r))
+	})
+}
+
+func TestPaddingStreamKeepsTrailingZeros(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		{0},
+		{0x80},
+		{0x80, 0, 0},
+		append([]byte{1, 2, 3}, make([]byte, 100*1024)...),
+		append(make([]byte, 100*1024), 0x80),
+	} {
+		b := bytes.NewBuffer(nil)
+		se := enc.NewPaddingStreamEncryptor(enc.NewDefaultStreamEncryptor(enc.BlankEncryptor(), b), pad.PowerOfTwoBucket)
+		_, err := se.Write(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = se.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sd := enc.NewPaddingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), b))
+		res, err := ioutil.ReadAll(sd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = sd.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res, data) {
+			t.Fatalf("Decrypted data differs for input of size %d", len(data))
+		}
+	}
+}
+
+func TestPaddingStreamDetectsMissingPadding(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	se := enc.NewDefaultStreamEncryptor(enc.BlankEncryptor(), b)
+	_, err := se.Write([]byte{1, 2, 3, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd := enc.NewPaddingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), b))
+	_, err = ioutil.ReadAll(sd)
+	if !errors.Is(err, uciph.ErrCiphertextInvalid) {
+		t.Fatalf("Expected ErrCiphertextInvalid, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package enc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/cutil/pwhash"
+	"github.com/teawithsand/uciph/rand"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Passphrase stream is: passphrase header followed by stream created with NewSubkeyStreamEncryptor.
+// Master key of that stream is derived from passphrase with Argon2id.
+//
+// Header format is:
+// magic(4 bytes), version(1 byte), memory in KiB(4 byte big endian), time(4 byte big endian),
+// threads(1 byte), salt(16 bytes) and HMAC-SHA256 of all previous bytes, which uses key derived from master key.
+// MAC lets decryptor detect wrong passphrase before any data is read.
+
+var passphraseHeaderMagic = [4]byte{'U', 'C', 'P', 'H'}
+
+const passphraseHeaderVersion = 1
+
+const (
+	passphraseSaltSize   = 16
+	passphraseParamsSize = 4 + 1 + 4 + 4 + 1 + passphraseSaltSize
+	passphraseHeaderSize = passphraseParamsSize + sha256.Size
+	passphraseKeySize    = 32
+)
+
+const passphraseMACInfo = "uciph/enc passphrase header mac"
+
+// Default Argon2id parameters(RFC 9106 second recommended option) and default limits for decryption.
+var (
+	defaultPassphraseArgon2 = pwhash.Argon2Options{
+		Memory:  64 * 1024,
+		Time:    3,
+		Threads: 4,
+	}
+	defaultPassphraseMaxArgon2 = pwhash.Argon2Options{
+		Memory:  256 * 1024,
+		Time:    16,
+		Threads: 16,
+	}
+)
+
+// PassphraseOptions configures derivation of stream key from passphrase.
+//
+// Argon2 is used by encryptor. Zero fields mean defaults: 64 MiB of memory, 3 passes and 4 threads.
+// MaxArgon2 is cost ceiling used by decryptor. Headers with any parameter above it are rejected,
+// so malicious stream can't make decryptor use arbitrary amount of memory or time.
+// Zero fields mean defaults: 256 MiB of memory, 16 passes and 16 threads.
+// KeyLen is ignored in both of them.
+type PassphraseOptions struct {
+	Argon2    pwhash.Argon2Options
+	MaxArgon2 pwhash.Argon2Options
+}
+
+// PassphraseOptionsProvider is kind of options, which provides PassphraseOptions.
+type PassphraseOptionsProvider interface {
+	GetPassphraseOptions() PassphraseOptions
+}
+
+// GetPassphraseOptions gets passphrase options from specified options.
+// If options do not provide any, zero PassphraseOptions are returned.
+func GetPassphraseOptions(options interface{}) (po PassphraseOptions) {
+	if popts, ok := options.(PassphraseOptionsProvider); ok {
+		po = popts.GetPassphraseOptions()
+	}
+	return
+}
+
+// GetPassphraseOptions makes PassphraseOptions PassphraseOptionsProvider, so they can be used as options.
+func (po PassphraseOptions) GetPassphraseOptions() PassphraseOptions {
+	return po
+}
+
+func (po PassphraseOptions) withDefaults() PassphraseOptions {
+	fill := func(o *pwhash.Argon2Options, def pwhash.Argon2Options) {
+		if o.Memory == 0 {
+			o.Memory = def.Memory
+		}
+		if o.Time == 0 {
+			o.Time = def.Time
+		}
+		if o.Threads == 0 {
+			o.Threads = def.Threads
+		}
+	}
+	fill(&po.Argon2, defaultPassphraseArgon2)
+	fill(&po.MaxArgon2, defaultPassphraseMaxArgon2)
+	return po
+}
+
+func passphraseHeaderMAC(key, data []byte) (mac []byte, err error) {
+	macKey := make([]byte, sha256.Size)
+	_, err = io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(passphraseMACInfo)), macKey)
+	if err != nil {
+		return
+	}
+	m := hmac.New(sha256.New, macKey)
+	m.Write(data)
+	mac = m.Sum(nil)
+	return
+}
+
+// NewPassphraseStreamEncryptor creates StreamEncryptor, which encrypts stream with key derived from passphrase
+// with Argon2id. Argon2id parameters and salt are written in header, so only passphrase is needed to decrypt it.
+//
+// Parameters are taken from PassphraseOptions from options. Salt is generated with RNG from options.
+// Header is written, when encryptor is created.
+func NewPassphraseStreamEncryptor(passphrase []byte, cipher CipherID, w io.Writer, options interface{}) (se StreamEncryptor, err error) {
+	params := GetPassphraseOptions(options).withDefaults().Argon2
+
+	header := make([]byte, passphraseParamsSize, passphraseHeaderSize)
+	copy(header[:4], passphraseHeaderMagic[:])
+	header[4] = passphraseHeaderVersion
+	binary.BigEndian.PutUint32(header[5:], params.Memory)
+	binary.BigEndian.PutUint32(header[9:], params.Time)
+	header[13] = params.Threads
+	salt := header[14:]
+	_, err = io.ReadFull(rand.GetRNG(options), salt)
+	if err != nil {
+		return
+	}
+
+	key := argon2.IDKey(passphrase, salt, params.Time, params.Memory, params.Threads, passphraseKeySize)
+	mac, err := passphraseHeaderMAC(key, header)
+	if err != nil {
+		return
+	}
+	header = append(header, mac...)
+
+	_, err = w.Write(header)
+	if err != nil {
+		return
+	}
+	return NewSubkeyStreamEncryptor(key, cipher, w, options)
+}
+
+// NewPassphraseStreamDecryptor reads passphrase header and creates StreamDecryptor for stream
+// created with NewPassphraseStreamEncryptor.
+//
+// Header with Argon2id parameters above MaxArgon2 from PassphraseOptions yields ErrStreamHeaderInvalid
+// without running Argon2id. Wrong passphrase yields ErrKeyInvalid.
+// Ciphers are ones, which are allowed to be used to encrypt body.
+func NewPassphraseStreamDecryptor(passphrase []byte, ciphers []CipherID, r io.Reader, options interface{}) (sd StreamDecryptor, err error) {
+	var header [passphraseHeaderSize]byte
+	_, err = io.ReadFull(r, header[:])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = uciph.ErrStreamHeaderInvalid
+		return
+	} else if err != nil {
+		return
+	}
+
+	var magic [4]byte
+	copy(magic[:], header[:4])
+	if magic != passphraseHeaderMagic || header[4] != passphraseHeaderVersion {
+		err = uciph.ErrStreamHeaderInvalid
+		return
+	}
+
+	params := pwhash.Argon2Options{
+		Memory:  binary.BigEndian.Uint32(header[5:]),
+		Time:    binary.BigEndian.Uint32(header[9:]),
+		Threads: header[13],
+	}
+	limit := GetPassphraseOptions(options).withDefaults().MaxArgon2
+	if params.Memory == 0 || params.Time == 0 || params.Threads == 0 ||
+		params.Memory > limit.Memory || params.Time > limit.Time || params.Threads > limit.Threads {
+		err = uciph.ErrStreamHeaderInvalid
+		return
+	}
+	salt := header[14:passphraseParamsSize]
+
+	key := argon2.IDKey(passphrase, salt, params.Time, params.Memory, params.Threads, passphraseKeySize)
+	mac, err := passphraseHeaderMAC(key, header[:passphraseParamsSize])
+	if err != nil {
+		return
+	}
+	if !hmac.Equal(mac, header[passphraseParamsSize:]) {
+		err = uciph.ErrKeyInvalid
+		return
+	}
+
+	return NewSubkeyStreamDecryptor(sameKeyForCiphers(key, ciphers), r, options)
+}
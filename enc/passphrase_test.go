@@ -0,0 +1,99 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/cutil/pwhash"
+	"github.com/teawithsand/uciph/enc"
+)
+
+// cheap parameters, so tests run fast
+var testPassphraseOptions = copts.Options{}.WithPassphraseOptions(enc.PassphraseOptions{
+	Argon2: pwhash.Argon2Options{Memory: 1024, Time: 1, Threads: 1},
+})
+
+func encryptWithPassphrase(t *testing.T, passphrase, data []byte, opts interface{}) []byte {
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewPassphraseStreamEncryptor(passphrase, enc.CipherChaCha20Poly1305, b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestPassphraseStreamED(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+	ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+		se, err := enc.NewPassphraseStreamEncryptor(passphrase, enc.CipherChaCha20Poly1305, w, testPassphraseOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return se
+	}, func(r io.Reader) enc.StreamDecryptor {
+		// parameters are read from header
+		sd, err := enc.NewPassphraseStreamDecryptor(passphrase, []enc.CipherID{enc.CipherChaCha20Poly1305}, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sd
+	})
+}
+
+func TestPassphraseStreamWrongPassphrase(t *testing.T) {
+	encrypted := encryptWithPassphrase(t, []byte("passphrase"), []byte("data"), testPassphraseOptions)
+	_, err := enc.NewPassphraseStreamDecryptor([]byte("Passphrase"), []enc.CipherID{enc.CipherChaCha20Poly1305}, bytes.NewReader(encrypted), nil)
+	if !errors.Is(err, uciph.ErrKeyInvalid) {
+		t.Fatalf("Expected ErrKeyInvalid, got %v", err)
+	}
+}
+
+func TestPassphraseStreamCostCeiling(t *testing.T) {
+	passphrase := []byte("passphrase")
+	encrypted := encryptWithPassphrase(t, passphrase, []byte("data"), testPassphraseOptions)
+
+	for _, limit := range []pwhash.Argon2Options{
+		{Memory: 512},
+		{Time: 1, Memory: 1023},
+	} {
+		opts := copts.Options{}.WithPassphraseOptions(enc.PassphraseOptions{MaxArgon2: limit})
+		_, err := enc.NewPassphraseStreamDecryptor(passphrase, []enc.CipherID{enc.CipherChaCha20Poly1305}, bytes.NewReader(encrypted), opts)
+		if !errors.Is(err, uciph.ErrStreamHeaderInvalid) {
+			t.Fatalf("Expected ErrStreamHeaderInvalid, got %v", err)
+		}
+	}
+
+	// huge memory is rejected without running Argon2id
+	tampered := append([]byte{}, encrypted...)
+	tampered[5] = 0xff
+	_, err := enc.NewPassphraseStreamDecryptor(passphrase, []enc.CipherID{enc.CipherChaCha20Poly1305}, bytes.NewReader(tampered), nil)
+	if !errors.Is(err, uciph.ErrStreamHeaderInvalid) {
+		t.Fatalf("Expected ErrStreamHeaderInvalid, got %v", err)
+	}
+
+	sd, err := enc.NewPassphraseStreamDecryptor(passphrase, []enc.CipherID{enc.CipherChaCha20Poly1305}, bytes.NewReader(encrypted), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ioutil.ReadAll(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != "data" {
+		t.Fatal("Decrypted data differs")
+	}
+}
@@ -0,0 +1,319 @@
+package enc
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"io"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/kx"
+	"github.com/teawithsand/uciph/rand"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Multi recipient stream is: recipients header followed by stream created with NewSubkeyStreamEncryptor.
+// Random file key is used as master key of that stream. It's wrapped once per recipient:
+// wrap key is derived with HKDF from Curve25519 KX of ephemeral key and recipient's public key and
+// file key is encrypted with it using ChaCha20Poly1305.
+//
+// Header format is:
+// magic(4 bytes), version(1 byte), stanza count(2 byte big endian), stanzas and HMAC-SHA256 of all previous bytes,
+// which uses key derived from file key.
+// Each stanza is: ephemeral public key(32 bytes) and wrapped file key(48 bytes).
+//
+// Body does not depend on header, so recipients may be added by rewriting header only.
+
+var recipientsHeaderMagic = [4]byte{'U', 'C', 'R', 'H'}
+
+const recipientsHeaderVersion = 1
+
+const (
+	// FileKeySize is size of random key used to encrypt body of multi recipient stream.
+	FileKeySize = 32
+
+	recipientStanzaSize     = curve25519.PointSize + FileKeySize + 16 // ChaCha20Poly1305 tag is 16 bytes
+	recipientsHeaderMACSize = sha256.Size
+
+	// MaxRecipients is max number of recipients of single stream.
+	MaxRecipients = 1<<16 - 1
+)
+
+const (
+	recipientWrapInfo = "uciph/enc recipient wrap"
+	recipientsMACInfo = "uciph/enc recipients header mac"
+)
+
+// RecipientsHeader is header of multi recipient stream.
+// It contains file key wrapped for each recipient.
+type RecipientsHeader struct {
+	Stanzas [][]byte
+}
+
+// recipientWrapAEAD creates AEAD, which wraps file key for single recipient.
+// Both public keys are used as salt, so wrap key is bound to them.
+func recipientWrapAEAD(shared, ephemeralPublic, recipientPublic []byte) (aead cipher.AEAD, err error) {
+	var zero [32]byte
+	// low order points give all zero result, which is known to everybody
+	if subtle.ConstantTimeCompare(shared, zero[:]) == 1 {
+		err = uciph.ErrKeyInvalid
+		return
+	}
+
+	salt := make([]byte, 0, len(ephemeralPublic)+len(recipientPublic))
+	salt = append(salt, ephemeralPublic...)
+	salt = append(salt, recipientPublic...)
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	_, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(recipientWrapInfo)), key)
+	if err != nil {
+		return
+	}
+	return NewChaCha20Poly1305AEAD(key)
+}
+
+// AddRecipients wraps file key for each of given Curve25519 public keys and appends resulting stanzas to header.
+func (h *RecipientsHeader) AddRecipients(fileKey []byte, recipients [][]byte, options interface{}) (err error) {
+	if len(fileKey) != FileKeySize {
+		return uciph.ErrKeyInvalid
+	}
+	if len(h.Stanzas)+len(recipients) > MaxRecipients {
+		return uciph.ErrStreamOptionsInvalid
+	}
+
+	stanzas := make([][]byte, 0, len(recipients))
+	for _, pk := range recipients {
+		ephemeral := &kx.Generated{}
+		err = kx.GenCurve25519(options, ephemeral)
+		if err != nil {
+			return
+		}
+		var shared []byte
+		shared, err = kx.Curve25519(options, pk, ephemeral.SecretPart, nil)
+		if err != nil {
+			return
+		}
+
+		var aead cipher.AEAD
+		aead, err = recipientWrapAEAD(shared, ephemeral.PublicPart, pk)
+		if err != nil {
+			return
+		}
+
+		var nonce [chacha20poly1305.NonceSize]byte
+		stanza := make([]byte, 0, recipientStanzaSize)
+		stanza = append(stanza, ephemeral.PublicPart...)
+		stanza = aead.Seal(stanza, nonce[:], fileKey, nil)
+		stanzas = append(stanzas, stanza)
+	}
+
+	h.Stanzas = append(h.Stanzas, stanzas...)
+	return
+}
+
+// FileKey tries to unwrap file key from each stanza with given Curve25519 secret key.
+// ErrNoMatchingRecipient is returned if none of them can be unwrapped.
+//
+// Note: returned key is not verified against header MAC. ReadRecipientsHeader does that.
+func (h *RecipientsHeader) FileKey(secretKey []byte, options interface{}) (fileKey []byte, err error) {
+	publicKey, err := kx.Curve25519(options, curve25519.Basepoint, secretKey, nil)
+	if err != nil {
+		return
+	}
+
+	for _, stanza := range h.Stanzas {
+		if len(stanza) != recipientStanzaSize {
+			return nil, uciph.ErrStreamHeaderInvalid
+		}
+		ephemeralPublic := stanza[:curve25519.PointSize]
+
+		var shared []byte
+		shared, err = kx.Curve25519(options, ephemeralPublic, secretKey, nil)
+		if err != nil {
+			return
+		}
+		aead, werr := recipientWrapAEAD(shared, ephemeralPublic, publicKey)
+		if werr != nil {
+			continue
+		}
+
+		var nonce [chacha20poly1305.NonceSize]byte
+		fileKey, werr = aead.Open(nil, nonce[:], stanza[curve25519.PointSize:], nil)
+		if werr == nil {
+			return
+		}
+	}
+	return nil, uciph.ErrNoMatchingRecipient
+}
+
+func recipientsHeaderMAC(fileKey, data []byte) (mac []byte, err error) {
+	key := make([]byte, sha256.Size)
+	_, err = io.ReadFull(hkdf.New(sha256.New, fileKey, nil, []byte(recipientsMACInfo)), key)
+	if err != nil {
+		return
+	}
+	m := hmac.New(sha256.New, key)
+	m.Write(data)
+	mac = m.Sum(nil)
+	return
+}
+
+// Marshal encodes header and authenticates it with MAC, which uses key derived from file key.
+func (h *RecipientsHeader) Marshal(fileKey []byte) (data []byte, err error) {
+	if len(h.Stanzas) == 0 || len(h.Stanzas) > MaxRecipients {
+		err = uciph.ErrStreamOptionsInvalid
+		return
+	}
+
+	data = make([]byte, 7, 7+len(h.Stanzas)*recipientStanzaSize+recipientsHeaderMACSize)
+	copy(data[:4], recipientsHeaderMagic[:])
+	data[4] = recipientsHeaderVersion
+	binary.BigEndian.PutUint16(data[5:], uint16(len(h.Stanzas)))
+	for _, stanza := range h.Stanzas {
+		if len(stanza) != recipientStanzaSize {
+			return nil, uciph.ErrStreamHeaderInvalid
+		}
+		data = append(data, stanza...)
+	}
+
+	mac, err := recipientsHeaderMAC(fileKey, data)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, mac...)
+	return
+}
+
+// ReadRecipientsHeader reads recipients header from the beginning of multi recipient stream,
+// unwraps file key with given Curve25519 secret key and verifies header with it.
+//
+// Stanza count can't be trusted before header is verified, so stanzas are read one by one
+// and memory used is proportional to size of header actually read.
+func ReadRecipientsHeader(secretKey []byte, r io.Reader, options interface{}) (h RecipientsHeader, fileKey []byte, err error) {
+	var prefix [7]byte
+	_, err = io.ReadFull(r, prefix[:])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = uciph.ErrStreamHeaderInvalid
+		return
+	} else if err != nil {
+		return
+	}
+
+	var magic [4]byte
+	copy(magic[:], prefix[:4])
+	count := int(binary.BigEndian.Uint16(prefix[5:]))
+	if magic != recipientsHeaderMagic || prefix[4] != recipientsHeaderVersion || count == 0 {
+		err = uciph.ErrStreamHeaderInvalid
+		return
+	}
+
+	data := append([]byte{}, prefix[:]...)
+	var readHeader RecipientsHeader
+	for i := 0; i < count; i++ {
+		stanza := make([]byte, recipientStanzaSize)
+		_, err = io.ReadFull(r, stanza)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = uciph.ErrStreamHeaderInvalid
+			return
+		} else if err != nil {
+			return
+		}
+		readHeader.Stanzas = append(readHeader.Stanzas, stanza)
+		data = append(data, stanza...)
+	}
+
+	var readMAC [recipientsHeaderMACSize]byte
+	_, err = io.ReadFull(r, readMAC[:])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = uciph.ErrStreamHeaderInvalid
+		return
+	} else if err != nil {
+		return
+	}
+
+	fileKey, err = readHeader.FileKey(secretKey, options)
+	if err != nil {
+		return
+	}
+
+	mac, err := recipientsHeaderMAC(fileKey, data)
+	if err != nil {
+		return
+	}
+	if !hmac.Equal(mac, readMAC[:]) {
+		err = uciph.ErrStreamHeaderInvalid
+		return
+	}
+
+	h = readHeader
+	return
+}
+
+// NewMultiRecipientStreamEncryptor creates StreamEncryptor, which encrypts stream once, so it can be
+// decrypted by owner of secret key of any of given Curve25519 public keys.
+//
+// File key and ephemeral keys are generated with RNG from options. Cipher is used to encrypt body.
+// Header is written, when encryptor is created.
+func NewMultiRecipientStreamEncryptor(recipients [][]byte, cipher CipherID, w io.Writer, options interface{}) (se StreamEncryptor, err error) {
+	fileKey := make([]byte, FileKeySize)
+	_, err = io.ReadFull(rand.GetRNG(options), fileKey)
+	if err != nil {
+		return
+	}
+
+	var h RecipientsHeader
+	err = h.AddRecipients(fileKey, recipients, options)
+	if err != nil {
+		return
+	}
+	data, err := h.Marshal(fileKey)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return
+	}
+
+	return NewSubkeyStreamEncryptor(fileKey, cipher, w, options)
+}
+
+// NewMultiRecipientStreamDecryptor reads recipients header and creates StreamDecryptor for stream
+// created with NewMultiRecipientStreamEncryptor. Secret key has to match one of recipients.
+//
+// Ciphers are ones, which are allowed to be used to encrypt body.
+func NewMultiRecipientStreamDecryptor(secretKey []byte, ciphers []CipherID, r io.Reader, options interface{}) (sd StreamDecryptor, err error) {
+	_, fileKey, err := ReadRecipientsHeader(secretKey, r, options)
+	if err != nil {
+		return
+	}
+
+	return NewSubkeyStreamDecryptor(sameKeyForCiphers(fileKey, ciphers), r, options)
+}
+
+// AddStreamRecipients copies multi recipient stream from r to w adding given recipients to its header.
+// Secret key has to match one of existing recipients. Body is copied as is, it's not decrypted nor encrypted again.
+func AddStreamRecipients(secretKey []byte, recipients [][]byte, r io.Reader, w io.Writer, options interface{}) (err error) {
+	h, fileKey, err := ReadRecipientsHeader(secretKey, r, options)
+	if err != nil {
+		return
+	}
+	err = h.AddRecipients(fileKey, recipients, options)
+	if err != nil {
+		return
+	}
+	data, err := h.Marshal(fileKey)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(w, r)
+	return
+}
@@ -0,0 +1,164 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/enc"
+	"github.com/teawithsand/uciph/kx"
+)
+
+func makeRecipients(t *testing.T, n int) (public, secret [][]byte) {
+	for i := 0; i < n; i++ {
+		g := &kx.Generated{}
+		err := kx.GenCurve25519(nil, g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public = append(public, g.PublicPart)
+		secret = append(secret, g.SecretPart)
+	}
+	return
+}
+
+func encryptMultiRecipient(t *testing.T, recipients [][]byte, data []byte) []byte {
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewMultiRecipientStreamEncryptor(recipients, enc.CipherChaCha20Poly1305, b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func decryptMultiRecipient(secretKey, encrypted []byte) (res []byte, err error) {
+	sd, err := enc.NewMultiRecipientStreamDecryptor(secretKey, []enc.CipherID{enc.CipherChaCha20Poly1305}, bytes.NewReader(encrypted), nil)
+	if err != nil {
+		return
+	}
+	res, err = ioutil.ReadAll(sd)
+	if err != nil {
+		return
+	}
+	err = sd.Close()
+	return
+}
+
+func TestMultiRecipientStreamED(t *testing.T) {
+	public, secret := makeRecipients(t, 3)
+	ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+		se, err := enc.NewMultiRecipientStreamEncryptor(public, enc.CipherChaCha20Poly1305, w, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return se
+	}, func(r io.Reader) enc.StreamDecryptor {
+		sd, err := enc.NewMultiRecipientStreamDecryptor(secret[2], []enc.CipherID{enc.CipherChaCha20Poly1305}, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sd
+	})
+}
+
+func TestMultiRecipientStreamEachRecipientDecrypts(t *testing.T) {
+	public, secret := makeRecipients(t, 5)
+	_, otherSecret := makeRecipients(t, 1)
+	data := []byte("shared with five people")
+	encrypted := encryptMultiRecipient(t, public, data)
+
+	for _, sk := range secret {
+		res, err := decryptMultiRecipient(sk, encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res, data) {
+			t.Fatal("Decrypted data differs")
+		}
+	}
+
+	_, err := decryptMultiRecipient(otherSecret[0], encrypted)
+	if !errors.Is(err, uciph.ErrNoMatchingRecipient) {
+		t.Fatalf("Expected ErrNoMatchingRecipient, got %v", err)
+	}
+}
+
+func TestAddStreamRecipients(t *testing.T) {
+	public, secret := makeRecipients(t, 1)
+	newPublic, newSecret := makeRecipients(t, 2)
+	data := make([]byte, 100*1024)
+	encrypted := encryptMultiRecipient(t, public, data)
+
+	b := bytes.NewBuffer(nil)
+	err := enc.AddStreamRecipients(secret[0], newPublic, bytes.NewReader(encrypted), b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewritten := b.Bytes()
+
+	// only header changes: magic, version, count, stanzas and MAC
+	body := encrypted[7+80+32:]
+	if len(rewritten) != 7+80*3+32+len(body) || !bytes.HasSuffix(rewritten, body) {
+		t.Fatal("Body has been changed")
+	}
+
+	for _, sk := range append(secret, newSecret...) {
+		res, err := decryptMultiRecipient(sk, rewritten)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res, data) {
+			t.Fatal("Decrypted data differs")
+		}
+	}
+
+	_, err = decryptMultiRecipient(newSecret[0], encrypted)
+	if !errors.Is(err, uciph.ErrNoMatchingRecipient) {
+		t.Fatalf("Expected ErrNoMatchingRecipient, got %v", err)
+	}
+}
+
+func TestMultiRecipientHeaderIsAuthenticated(t *testing.T) {
+	public, secret := makeRecipients(t, 2)
+	encrypted := encryptMultiRecipient(t, public, []byte("data"))
+
+	// drop second stanza, keeping first one valid
+	tampered := append([]byte{}, encrypted...)
+	tampered[6] = 1
+	tampered = append(tampered[:7+80], tampered[7+80*2:]...)
+	_, err := decryptMultiRecipient(secret[0], tampered)
+	if !errors.Is(err, uciph.ErrStreamHeaderInvalid) {
+		t.Fatalf("Expected ErrStreamHeaderInvalid, got %v", err)
+	}
+}
+
+func TestReadRecipientsHeaderTruncatedHugeCount(t *testing.T) {
+	_, secret := makeRecipients(t, 1)
+
+	// header claims max count of stanzas, but contains only one
+	header := append([]byte("UCRH\x01\xff\xff"), make([]byte, 80)...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err := enc.ReadRecipientsHeader(secret[0], bytes.NewReader(header), nil)
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, uciph.ErrStreamHeaderInvalid) {
+		t.Fatalf("Expected ErrStreamHeaderInvalid, got %v", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64*1024 {
+		t.Fatalf("Expected allocations to depend on data read, got %d bytes allocated", allocated)
+	}
+}
@@ -0,0 +1,281 @@
+package enc
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/cutil"
+	"github.com/teawithsand/uciph/enc/internal"
+	"golang.org/x/crypto/hkdf"
+)
+
+// defaultRekeyChunksPerEpoch is used when neither chunk nor byte limit is set.
+const defaultRekeyChunksPerEpoch = 1 << 20
+
+// rekeyEpochSize is size of epoch appended to each chunk.
+const rekeyEpochSize = 8
+
+const (
+	rekeyInitialInfo = "uciph/enc rekey initial key"
+	rekeyRatchetInfo = "uciph/enc rekey ratchet"
+)
+
+// RekeyOptions configures how often rekeying encryptor changes its key.
+// Key is changed once any of limits is reached. Zero means no limit.
+// If both are zero, key is changed every 2**20 chunks.
+//
+// BytesPerEpoch counts bytes of chunks given to encryptor, not only plaintext.
+// Default stream encryptor passes counter and flags of each chunk along with its data,
+// so epoch of stream ends slightly before BytesPerEpoch bytes of plaintext are encrypted.
+//
+// Decryptor does not need them, since epoch is stored in each chunk.
+type RekeyOptions struct {
+	ChunksPerEpoch uint64
+	BytesPerEpoch  uint64
+}
+
+// RekeyOptionsProvider is kind of options, which provides RekeyOptions.
+type RekeyOptionsProvider interface {
+	GetRekeyOptions() RekeyOptions
+}
+
+// GetRekeyOptions gets rekey options from specified options.
+// If options do not provide any, zero RekeyOptions are returned.
+func GetRekeyOptions(options interface{}) (ro RekeyOptions) {
+	if ropts, ok := options.(RekeyOptionsProvider); ok {
+		ro = ropts.GetRekeyOptions()
+	}
+	return
+}
+
+// GetRekeyOptions makes RekeyOptions RekeyOptionsProvider, so they can be used as options.
+func (ro RekeyOptions) GetRekeyOptions() RekeyOptions {
+	return ro
+}
+
+// rekeyState is state shared by rekeying encryptor and decryptor.
+// Key of each epoch is derived from key of previous one, which is then forgotten,
+// so compromise of current key does not reveal chunks encrypted in previous epochs.
+type rekeyState struct {
+	Cipher CipherID
+	Key    []byte
+	Epoch  uint64
+
+	AEAD  cipher.AEAD
+	Nonce cutil.NonceCounter
+	AD    []byte // epoch followed by additional data given by user
+}
+
+func newRekeyState(key []byte, id CipherID, options interface{}) (s *rekeyState, err error) {
+	keySize := id.KeySize()
+	if keySize < 0 {
+		err = uciph.ErrCipherNotAllowed
+		return
+	}
+	if len(key) == 0 {
+		err = uciph.ErrKeyInvalid
+		return
+	}
+
+	s = &rekeyState{
+		Cipher: id,
+		Key:    make([]byte, keySize),
+		AD:     append(make([]byte, rekeyEpochSize), GetAdditionalData(options)...),
+	}
+	_, err = io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(rekeyInitialInfo)), s.Key)
+	if err != nil {
+		return
+	}
+	err = s.init()
+	return
+}
+
+// init creates AEAD for current epoch.
+func (s *rekeyState) init() (err error) {
+	s.AEAD, err = s.Cipher.NewAEAD(s.Key)
+	if err != nil {
+		return
+	}
+	s.Nonce = cutil.NonceCounterForAEAD(s.AEAD)
+	binary.BigEndian.PutUint64(s.AD[:rekeyEpochSize], s.Epoch)
+	return
+}
+
+// next derives state of next epoch. Current state is not modified.
+func (s *rekeyState) next() (n *rekeyState, err error) {
+	if s.Epoch == ^uint64(0) {
+		err = uciph.ErrTooManyChunksEncrypted
+		return
+	}
+
+	n = &rekeyState{
+		Cipher: s.Cipher,
+		Key:    make([]byte, len(s.Key)),
+		Epoch:  s.Epoch + 1,
+		AD:     append([]byte{}, s.AD...),
+	}
+	_, err = io.ReadFull(hkdf.New(sha256.New, s.Key, nil, []byte(rekeyRatchetInfo)), n.Key)
+	if err != nil {
+		return
+	}
+	err = n.init()
+	return
+}
+
+// forget wipes key and drops AEAD created from it.
+// AEAD's key schedule can't be wiped, but it's not referenced anymore, so it can be garbage collected.
+func (s *rekeyState) forget() {
+	wipe(s.Key)
+	s.AEAD = nil
+}
+
+// ratchet moves state to next epoch and forgets current key.
+func (s *rekeyState) ratchet() (err error) {
+	n, err := s.next()
+	if err != nil {
+		return
+	}
+	s.forget()
+	*s = *n
+	return
+}
+
+type rekeyEncryptor struct {
+	State *rekeyState
+
+	ChunksPerEpoch uint64
+	BytesPerEpoch  uint64
+
+	EpochChunks uint64
+	EpochBytes  uint64
+}
+
+func (e *rekeyEncryptor) Encrypt(in, appendTo []byte) (res []byte, err error) {
+	if (e.ChunksPerEpoch != 0 && e.EpochChunks >= e.ChunksPerEpoch) ||
+		(e.BytesPerEpoch != 0 && e.EpochBytes >= e.BytesPerEpoch) {
+		err = e.State.ratchet()
+		if err != nil {
+			return
+		}
+		e.EpochChunks = 0
+		e.EpochBytes = 0
+	}
+
+	if internal.AnyOverlap(in, appendTo) && internal.InexactOverlap(in, appendTo) {
+		appendTo = nil
+	}
+
+	s := e.State
+	res = s.AEAD.Seal(appendTo, s.Nonce, in, s.AD)
+	// epoch is appended rather than prepended, so encryption in place works
+	res = append(res, s.AD[:rekeyEpochSize]...)
+
+	e.EpochChunks++
+	e.EpochBytes += uint64(len(in))
+	err = s.Nonce.Increment()
+	return
+}
+
+type rekeyDecryptor struct {
+	State *rekeyState
+}
+
+func (d *rekeyDecryptor) Decrypt(in, appendTo []byte) (res []byte, err error) {
+	if len(in) < rekeyEpochSize {
+		err = uciph.ErrCiphertextInvalid
+		return
+	}
+	epoch := binary.BigEndian.Uint64(in[len(in)-rekeyEpochSize:])
+	in = in[:len(in)-rekeyEpochSize]
+
+	// Epoch may only stay same or move to next one. Previous keys are not available anymore.
+	// Next epoch is used only once chunk from it has been authenticated,
+	// so forged chunk can't make decryptor drop current key.
+	s := d.State
+	if epoch == s.Epoch+1 && s.Epoch != ^uint64(0) {
+		s, err = s.next()
+		if err != nil {
+			return
+		}
+	} else if epoch != s.Epoch {
+		err = uciph.ErrCiphertextInvalid
+		return
+	}
+
+	if internal.InexactOverlap(in, appendTo) {
+		appendTo = nil
+	}
+	res, err = s.AEAD.Open(appendTo, s.Nonce, in, s.AD)
+	if err != nil {
+		if s != d.State {
+			s.forget()
+		}
+		return
+	}
+	if s != d.State {
+		d.State.forget()
+		d.State = s
+	}
+	err = s.Nonce.Increment()
+	return
+}
+
+// NewRekeyingEncryptor creates Encryptor, which changes its key every epoch configured with RekeyOptions from options.
+// Key of next epoch is derived from current one with one way HKDF ratchet, so chunks encrypted in previous epochs
+// can't be decrypted with current key. Nonces are derived from counter, which starts from zero in each epoch.
+//
+// Epoch is appended to each chunk and authenticated along with additional data from options.
+// Key may have any length, actual keys for cipher are derived from it.
+func NewRekeyingEncryptor(key []byte, id CipherID, options interface{}) (e Encryptor, err error) {
+	s, err := newRekeyState(key, id, options)
+	if err != nil {
+		return
+	}
+
+	ro := GetRekeyOptions(options)
+	if ro.ChunksPerEpoch == 0 && ro.BytesPerEpoch == 0 {
+		ro.ChunksPerEpoch = defaultRekeyChunksPerEpoch
+	}
+	e = &rekeyEncryptor{
+		State:          s,
+		ChunksPerEpoch: ro.ChunksPerEpoch,
+		BytesPerEpoch:  ro.BytesPerEpoch,
+	}
+	return
+}
+
+// NewRekeyingDecryptor creates Decryptor, which decrypts chunks encrypted with NewRekeyingEncryptor.
+// It follows epochs stored in chunks, so it does not need RekeyOptions.
+// Chunks have to be decrypted in order.
+func NewRekeyingDecryptor(key []byte, id CipherID, options interface{}) (d Decryptor, err error) {
+	s, err := newRekeyState(key, id, options)
+	if err != nil {
+		return
+	}
+	d = &rekeyDecryptor{
+		State: s,
+	}
+	return
+}
+
+// NewRekeyingStreamEncryptor creates default stream encryptor, which uses NewRekeyingEncryptor to encrypt chunks,
+// so stream may be arbitrarily long. StreamOptions and RekeyOptions are taken from options.
+func NewRekeyingStreamEncryptor(key []byte, id CipherID, w io.Writer, options interface{}) (se StreamEncryptor, err error) {
+	e, err := NewRekeyingEncryptor(key, id, options)
+	if err != nil {
+		return
+	}
+	return NewDefaultStreamEncryptorWithOptions(e, w, options)
+}
+
+// NewRekeyingStreamDecryptor creates default stream decryptor, which decrypts stream created with NewRekeyingStreamEncryptor.
+func NewRekeyingStreamDecryptor(key []byte, id CipherID, r io.Reader, options interface{}) (sd StreamDecryptor, err error) {
+	d, err := NewRekeyingDecryptor(key, id, options)
+	if err != nil {
+		return
+	}
+	return NewDefaultStreamDecryptorWithOptions(d, r, options)
+}
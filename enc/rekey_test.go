@@ -0,0 +1,174 @@
+package enc_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/enc"
+)
+
+func TestRekeyingED(t *testing.T) {
+	for _, ro := range []enc.RekeyOptions{
+		{},
+		{ChunksPerEpoch: 1},
+		{ChunksPerEpoch: 3},
+		{BytesPerEpoch: 2000},
+	} {
+		opts := copts.Options{}.WithRekeyOptions(ro)
+		ctest.DoTestED(t, func() (enc.Encryptor, enc.Decryptor) {
+			key, err := enc.ChaCha20Poly1305Keygen(nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := enc.NewRekeyingEncryptor(key, enc.CipherChaCha20Poly1305, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := enc.NewRekeyingDecryptor(key, enc.CipherChaCha20Poly1305, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return e, d
+		}, ctest.TestEDConfig{
+			IsAEAD: true,
+		})
+	}
+}
+
+func TestRekeyingStreamED(t *testing.T) {
+	key, err := enc.ChaCha20Poly1305Keygen(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := copts.Options{}.
+		WithStreamOptions(enc.StreamOptions{ChunkSize: 1000}).
+		WithRekeyOptions(enc.RekeyOptions{ChunksPerEpoch: 10})
+
+	ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+		se, err := enc.NewRekeyingStreamEncryptor(key, enc.CipherAES256GCM, w, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return se
+	}, func(r io.Reader) enc.StreamDecryptor {
+		sd, err := enc.NewRekeyingStreamDecryptor(key, enc.CipherAES256GCM, r, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sd
+	})
+}
+
+func TestRekeyingChangesEpochs(t *testing.T) {
+	key, err := enc.ChaCha20Poly1305Keygen(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := enc.NewRekeyingEncryptor(key, enc.CipherChaCha20Poly1305, enc.RekeyOptions{ChunksPerEpoch: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks [][]byte
+	for i := 0; i < 6; i++ {
+		c, err := e.Encrypt([]byte("Hello world"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		epoch := binary.BigEndian.Uint64(c[len(c)-8:])
+		if epoch != uint64(i/2) {
+			t.Fatalf("Invalid epoch of chunk %d: %d", i, epoch)
+		}
+		chunks = append(chunks, c)
+	}
+
+	t.Run("EpochAuthenticated", func(t *testing.T) {
+		d, err := enc.NewRekeyingDecryptor(key, enc.CipherChaCha20Poly1305, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tampered := append([]byte{}, chunks[0]...)
+		tampered[len(tampered)-1] = 1
+		_, err = d.Decrypt(tampered, nil)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	})
+
+	t.Run("ForgedNextEpochKeepsKey", func(t *testing.T) {
+		d, err := enc.NewRekeyingDecryptor(key, enc.CipherChaCha20Poly1305, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = d.Decrypt(chunks[0], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forged := append([]byte{}, chunks[2]...)
+		forged[0] ^= 1
+		_, err = d.Decrypt(forged, nil)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		for _, c := range chunks[1:] {
+			_, err = d.Decrypt(c, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("EpochsCanNotBeSkipped", func(t *testing.T) {
+		d, err := enc.NewRekeyingDecryptor(key, enc.CipherChaCha20Poly1305, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = d.Decrypt(chunks[4], nil)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	})
+}
+
+func TestRekeyingStreamDetectsChunkRemovalAtEpochBoundary(t *testing.T) {
+	key, err := enc.ChaCha20Poly1305Keygen(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := copts.Options{}.
+		WithStreamOptions(enc.StreamOptions{ChunkSize: 100, ChunkLengthEncoding: enc.Byte4, ChunkCounterEncoding: enc.Byte4}).
+		WithRekeyOptions(enc.RekeyOptions{ChunksPerEpoch: 2})
+
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewRekeyingStreamEncryptor(key, enc.CipherChaCha20Poly1305, b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(make([]byte, 100*5+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// length + counter + flag + data + tag + epoch
+	frameSize := 4 + 4 + 1 + 100 + 16 + 8
+	encrypted := b.Bytes()
+	tampered := append(append([]byte{}, encrypted[:frameSize]...), encrypted[frameSize*2:]...)
+
+	sd, err := enc.NewRekeyingStreamDecryptor(key, enc.CipherChaCha20Poly1305, bytes.NewReader(tampered), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(sd)
+	if err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+}
@@ -9,13 +9,19 @@ import (
 	"github.com/teawithsand/uciph"
 )
 
-type intEncoding int8
+// IntEncoding describes how integers like chunk lengths and chunk counters are stored in stream.
+type IntEncoding int8
 
-func (e intEncoding) IsValid() bool {
+// noIntEncoding is used internally to mark that integer is not stored at all.
+const noIntEncoding IntEncoding = -1
+
+// IsValid returns true if this encoding is one of known encodings.
+func (e IntEncoding) IsValid() bool {
 	return e == ByteVar || e == Byte1 || e == Byte2 || e == Byte4 || e == Byte8
 }
 
-func (e intEncoding) Size(n uint64) int {
+// Size returns number of bytes required to encode given number or -1 if it can't be encoded.
+func (e IntEncoding) Size(n uint64) int {
 	var arr [10]byte
 	switch e {
 	case ByteVar:
@@ -30,27 +36,27 @@ func (e intEncoding) Size(n uint64) int {
 		if n > (1<<16)-1 {
 			return -1
 		}
-		return 1
+		return 2
 	case Byte4:
 		if n > (1<<32)-1 {
 			return -1
 		}
-		return 1
+		return 4
 	case Byte8:
-		if n > (1<<64)-1 {
-			return -1
-		}
-		return 1
+		return 8
 	default:
 		return -1
 	}
 }
 
-func (e intEncoding) Encode(buf []byte, n uint64) (sz int) {
+// Encode encodes number into buffer and returns count of bytes written.
+// Buffer has to be big enough. Number has to fit in encoding, which is checked with Size.
+func (e IntEncoding) Encode(buf []byte, n uint64) (sz int) {
 	switch e {
 	case ByteVar:
 		sz = binary.PutUvarint(buf, n)
 	case Byte1:
+		sz = 1
 		buf[0] = byte(n)
 	case Byte2:
 		sz = 2
@@ -83,7 +89,8 @@ func (r byteReaderExt) ReadByte() (b byte, err error) {
 	return
 }
 
-func (e intEncoding) Decode(r io.Reader) (n uint64, err error) {
+// Decode reads encoded number from reader.
+func (e IntEncoding) Decode(r io.Reader) (n uint64, err error) {
 	var b byte
 
 	var br io.ByteReader
@@ -124,37 +131,176 @@ func (e intEncoding) Decode(r io.Reader) (n uint64, err error) {
 		}
 		n = uint64(binary.BigEndian.Uint64(arr[:]))
 	default:
-		panic("NIY ERROR HERE INVALID INT ENCODING")
+		panic("uciph/enc: Invalid int encoding")
+	}
+	return
+}
+
+// DecodeBytes decodes number from the beginning of buffer.
+// It returns number of bytes used or -1 if buffer does not contain valid number.
+func (e IntEncoding) DecodeBytes(buf []byte) (n uint64, sz int) {
+	r := bytes.NewReader(buf)
+	n, err := e.Decode(r)
+	if err != nil {
+		return 0, -1
 	}
+	sz = len(buf) - r.Len()
 	return
 }
 
+var errStreamEncryptorClosed = errors.New("uciph/enc: Stream encryptor has been closed")
+
+var errStreamEncryptorExported = errors.New("uciph/enc: Stream encryptor state has been exported")
+
+// maxIntEncodingSize is max size of number encoded with any IntEncoding.
+const maxIntEncodingSize = binary.MaxVarintLen64
+
+// streamChunkPrefixSize is max size of data stored in chunk before actual data.
+// It's chunk counter and chunk flags.
+const streamChunkPrefixSize = maxIntEncodingSize + 1
+
+const (
+	// streamChunkFlagFinal marks last chunk of stream.
+	// Since it's encrypted along with data, stream can't be truncated at chunk boundary
+	// without being detected.
+	streamChunkFlagFinal = 1 << iota
+	// streamChunkFlagFlushed marks chunk written by Flush.
+	// It's not final, but may contain less than ChunkSize bytes of data.
+	streamChunkFlagFlushed
+)
+
 const (
-	// ByteVar note: in order to encode all ints it may take up to 9 bytes
-	ByteVar intEncoding = 0 //default is variable
-	Byte1   intEncoding = 1
-	Byte2   intEncoding = 2
-	Byte4   intEncoding = 4
-	Byte8   intEncoding = 8
+	// ByteVar encodes number as uvarint.
+	// Note: in order to encode all ints it may take up to 10 bytes
+	ByteVar IntEncoding = 0 //default is variable
+	// Byte1 encodes number as single byte.
+	Byte1 IntEncoding = 1
+	// Byte2 encodes number as 2 byte big endian integer.
+	Byte2 IntEncoding = 2
+	// Byte4 encodes number as 4 byte big endian integer.
+	Byte4 IntEncoding = 4
+	// Byte8 encodes number as 8 byte big endian integer.
+	Byte8 IntEncoding = 8
 )
 
+// defaultStreamChunkSize is amount of data stored in single chunk of default stream.
+const defaultStreamChunkSize = 1024 * 1024
+
+// defaultStreamMaxChunkOverhead is max difference between size of encrypted chunk and data it holds,
+// which is accepted by default stream decryptor.
+const defaultStreamMaxChunkOverhead = 1024
+
 type defaultStreamEncryptor struct {
-	DstBufferSize int
+	DstBufferSize int // Amount of data, which is stored in single chunk
 
 	CurrentEncBufferSize int
-	EncBuffer            []byte // It's preallocated and may have variable length due to in-place necryption
+	// EncBuffer is preallocated. It has streamChunkPrefixSize bytes reserved for chunk counter
+	// and flags at the beginning, so chunk may be encrypted in place.
+	EncBuffer []byte
 
 	Encryptor Encryptor
 
 	ChunkCounter         uint64
-	ChunkCounterEncoding intEncoding
-	ChunkLengthEncoding  intEncoding
+	ChunkCounterEncoding IntEncoding
+	ChunkLengthEncoding  IntEncoding
 
-	Sink io.Writer
+	Sink    io.Writer
+	Written uint64 // count of bytes written to sink
 
 	ErrorCache error
 }
 
+// makeStreamChunk encodes chunk counter and flags right before data stored in
+// buf[streamChunkPrefixSize:streamChunkPrefixSize+dataSize] and returns plaintext of chunk.
+func makeStreamChunk(
+	buf []byte,
+	dataSize int,
+	counter uint64,
+	flags byte,
+	counterEncoding IntEncoding,
+) (chunk []byte, err error) {
+	chunkStart := streamChunkPrefixSize
+	chunkEnd := streamChunkPrefixSize + dataSize
+
+	chunkStart--
+	buf[chunkStart] = flags
+
+	if counterEncoding.IsValid() {
+		sz := counterEncoding.Size(counter)
+		if sz < 0 {
+			err = uciph.ErrTooManyChunksEncrypted
+			return
+		}
+		chunkStart -= sz
+		counterEncoding.Encode(buf[chunkStart:], counter)
+	}
+
+	chunk = buf[chunkStart:chunkEnd]
+	return
+}
+
+// writeStreamFrame writes encrypted chunk to w. It's prefixed with its length, if lengths are written.
+func writeStreamFrame(w io.Writer, chunk []byte, lengthEncoding IntEncoding) (err error) {
+	if lengthEncoding.IsValid() {
+		var sizeBuffer [maxIntEncodingSize]byte
+		if lengthEncoding.Size(uint64(len(chunk))) < 0 {
+			err = uciph.ErrChunkTooBig
+			return
+		}
+		writtenSz := lengthEncoding.Encode(sizeBuffer[:], uint64(len(chunk)))
+		_, err = w.Write(sizeBuffer[:writtenSz])
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = w.Write(chunk)
+	return
+}
+
+// writeStreamTerminator writes terminator, if lengths are written.
+// It's not trusted by decryptor, since final chunk flag marks end of stream.
+// It's left only so stream structure can be inspected without decrypting it.
+func writeStreamTerminator(w io.Writer, lengthEncoding IntEncoding) (err error) {
+	if lengthEncoding.IsValid() {
+		var sizeBuffer [maxIntEncodingSize]byte
+		writtenSz := lengthEncoding.Encode(sizeBuffer[:], uint64(0))
+		_, err = w.Write(sizeBuffer[:writtenSz])
+	}
+	return
+}
+
+// writeChunk encrypts data stored in EncBuffer and writes it to sink.
+func (dse *defaultStreamEncryptor) writeChunk(flags byte) (err error) {
+	// 1. Write flags and chunk counter right before data
+	chunk, err := makeStreamChunk(dse.EncBuffer, dse.CurrentEncBufferSize, dse.ChunkCounter, flags, dse.ChunkCounterEncoding)
+	if err != nil {
+		return
+	}
+
+	// 2. Encrypt in place
+	res, err := dse.Encryptor.Encrypt(chunk, chunk[:0])
+	if err != nil {
+		return
+	}
+
+	// note: state may be corrupted if error is not cached
+	// so it has to be cached
+	dse.ChunkCounter++
+	dse.CurrentEncBufferSize = 0
+
+	// 3. Write chunk with its length(if required)
+	err = writeStreamFrame(dse.Sink, res, dse.ChunkLengthEncoding)
+	if err != nil {
+		return
+	}
+	dse.Written += uint64(len(res))
+	if dse.ChunkLengthEncoding.IsValid() {
+		dse.Written += uint64(dse.ChunkLengthEncoding.Size(uint64(len(res))))
+	}
+	return
+}
+
 func (dse *defaultStreamEncryptor) Close() (err error) {
 	if dse.ErrorCache != nil {
 		return dse.ErrorCache
@@ -166,49 +312,63 @@ func (dse *defaultStreamEncryptor) Close() (err error) {
 	}()
 	defer func() {
 		if err == nil {
-			dse.ErrorCache = errors.New("TODO ERROR STREAM ENCRYPTOR HAS BEEN CLOSED")
+			dse.ErrorCache = errStreamEncryptorClosed
 		}
 	}()
 
-	if dse.CurrentEncBufferSize > 0 {
-		// Enc buffer already contains size(if it's required)
-		// do not write it to enc buffer here
-		var buffer []byte
-		buffer, err = dse.Encryptor.Encrypt(dse.EncBuffer[:dse.CurrentEncBufferSize], dse.EncBuffer[:0])
-		if err != nil {
-			return
-		}
+	// Final chunk is always written, even if it contains no data.
+	err = dse.writeChunk(streamChunkFlagFinal)
+	if err != nil {
+		return
+	}
 
-		// Write length if required
-		{
-			var sizeBuffer [10]byte
-			if dse.ChunkLengthEncoding.IsValid() {
-				sz := dse.ChunkCounterEncoding.Encode(sizeBuffer[:], uint64(len(buffer)))
-				_, err = dse.Sink.Write(buffer[:sz])
-				if err != nil {
-					return
-				}
-			}
+	// Write terminator if required.
+	err = writeStreamTerminator(dse.Sink, dse.ChunkLengthEncoding)
+	return
+}
+
+// Flush encrypts and writes data buffered so far as short chunk, so it's not lost if encryptor is never closed.
+// Chunk is authenticated as any other, so reordering and truncation are still detected.
+// If sink has Flush method, like bufio.Writer, it's called as well.
+//
+// Flush requires chunk lengths, since flushed chunks are shorter than others.
+// Streams with flushed chunks can't be decrypted with NewSeekableStreamDecryptor or recovered with RecoverStream.
+func (dse *defaultStreamEncryptor) Flush() (err error) {
+	if dse.ErrorCache != nil {
+		return dse.ErrorCache
+	}
+	if !dse.ChunkLengthEncoding.IsValid() {
+		return uciph.ErrStreamOptionsInvalid
+	}
+	defer func() {
+		if err != nil {
+			dse.ErrorCache = err
 		}
+	}()
 
-		// and then write chunk
-		_, err = dse.Sink.Write(buffer)
+	if dse.CurrentEncBufferSize > 0 {
+		err = dse.writeChunk(streamChunkFlagFlushed)
 		if err != nil {
 			return
 		}
 	}
 
-	// Write terminator chunk if required
-	if dse.ChunkCounterEncoding.IsValid() {
-		// TODO(teawithsand): encrypt chunk counter with zero value and then write it's length
-		// it's much better terminator chunk
-		var sizeBuffer [9]byte
-		if dse.ChunkLengthEncoding.IsValid() {
-			writtenSz := dse.ChunkCounterEncoding.Encode(sizeBuffer[:], uint64(0))
-			_, err = dse.Sink.Write(sizeBuffer[:writtenSz])
-			if err != nil {
-				return
-			}
+	if f, ok := dse.Sink.(interface{ Flush() error }); ok {
+		err = f.Flush()
+	}
+	return
+}
+
+// Sync flushes encryptor and calls Sync method of sink, if it has one, like os.File.
+func (dse *defaultStreamEncryptor) Sync() (err error) {
+	err = dse.Flush()
+	if err != nil {
+		return
+	}
+	if s, ok := dse.Sink.(interface{ Sync() error }); ok {
+		err = s.Sync()
+		if err != nil {
+			dse.ErrorCache = err
 		}
 	}
 	return
@@ -225,116 +385,26 @@ func (dse *defaultStreamEncryptor) Write(data []byte) (sz int, err error) {
 	}()
 	sz = len(data)
 
-	// do not allow empty chunks
-	if len(data) == 0 {
-		return
-	}
-
-	if dse.DstBufferSize <= 0 {
-		// Allocate + encrypt here
-		// rather than use buffer, since data length is variable
-		// alternative is encBuffer with size equal to max passed chunk size
-		// or do some hybrid for usual chunk sizes
-		// right now it allocates always
-
-		bufSz := 0
-		numOffset := 0
-		if dse.ChunkCounterEncoding.IsValid() {
-			sz := dse.ChunkCounterEncoding.Size(dse.ChunkCounter)
-			if sz < 0 {
-				// TODO(teawithsand): return error here
-				panic("NIY INVALID SIZE")
-			}
-			numOffset = sz
-			bufSz += sz
-		}
-		bufSz += len(data)
-
-		buffer := make([]byte, bufSz)
-
-		// 1. Write chunk counter into buffer if enabled
-		if dse.ChunkCounterEncoding >= 0 {
-			sz := dse.ChunkCounterEncoding.Encode(dse.EncBuffer, dse.ChunkCounter)
-			dse.CurrentEncBufferSize += sz
-		}
-		dse.ChunkCounter++
+	for len(data) > 0 {
+		// TOOD(teawithsnad): optimize: when there is no data in EncBuffer
+		// then simply get data directly from data variable and specify EncBuffer as destination.
 
-		// 2. Copy data to buffer
-		copy(buffer[numOffset:], data) // this always copies whole data buffer
+		// 1. Fill encryption buffer with data
+		copiedSz := copy(
+			dse.EncBuffer[streamChunkPrefixSize+dse.CurrentEncBufferSize:streamChunkPrefixSize+dse.DstBufferSize],
+			data,
+		)
+		dse.CurrentEncBufferSize += copiedSz
 
-		// 3. Encrypt in place
-		buffer, err = dse.Encryptor.Encrypt(buffer, buffer[:0])
-		if err != nil {
-			return
-		}
-
-		// 4.0 Write chunk length(if required)
-		{
-			var sizeBuffer [9]byte
-			if dse.ChunkLengthEncoding.IsValid() {
-				writtenSz := dse.ChunkCounterEncoding.Encode(sizeBuffer[:], uint64(len(buffer)))
-				_, err = dse.Sink.Write(buffer[:writtenSz])
-				if err != nil {
-					return
-				}
-			}
-		}
-
-		// 4.1 Write data to sink
-		_, err = dse.Sink.Write(buffer)
-		if err != nil {
-			return
-		}
-	} else {
-		for len(data) > 0 {
-			// 1. Write chunk counter into buffer if enabled
-			if dse.CurrentEncBufferSize == 0 && dse.ChunkCounterEncoding >= 0 {
-				sz := dse.ChunkCounterEncoding.Encode(dse.EncBuffer, dse.ChunkCounter)
-				dse.CurrentEncBufferSize += sz
-			}
+		// note: possible state corruption if no error caching
+		data = data[copiedSz:]
 
-			// TOOD(teawithsnad): optimize: when there is no data in EncBuffer
-			// then simply get data directly from data variable and specify EncBuffer as destination.
-			// although it's not that simple when
-
-			// 2. Fill encryption buffer with data
-			copiedSz := copy(dse.EncBuffer[dse.CurrentEncBufferSize:dse.DstBufferSize], data[:])
-			dse.CurrentEncBufferSize += copiedSz
-
-			// note: possible state corruption if no error caching
-			data = data[copiedSz:]
-
-			// 3. If buffer is filled do encrypt in place and
-			// set it's current size to zero
-			if dse.CurrentEncBufferSize == dse.DstBufferSize {
-				dse.ChunkCounter++ // again, possible state corruption if no error caching
-
-				var res []byte
-				res, err = dse.Encryptor.Encrypt(dse.EncBuffer[:], dse.EncBuffer[:0])
-				if err != nil {
-					return
-				}
-
-				// Write chunk length(if required)
-				{
-					var sizeBuffer [9]byte
-					if dse.ChunkLengthEncoding.IsValid() {
-						writtenSz := dse.ChunkCounterEncoding.Encode(sizeBuffer[:], uint64(len(dse.EncBuffer)))
-						_, err = dse.Sink.Write(sizeBuffer[:writtenSz])
-						if err != nil {
-							return
-						}
-					}
-				}
-
-				// note: state may be corrupted if error is not cached
-				// so it has to be cached
-				dse.CurrentEncBufferSize = 0
-
-				_, err = dse.Sink.Write(res)
-				if err != nil {
-					return
-				}
+		// 2. If buffer is filled then encrypt it and write it
+		// It's never final chunk, since final chunk is written on close.
+		if dse.CurrentEncBufferSize == dse.DstBufferSize {
+			err = dse.writeChunk(0)
+			if err != nil {
+				return
 			}
 		}
 	}
@@ -343,7 +413,7 @@ func (dse *defaultStreamEncryptor) Write(data []byte) (sz int, err error) {
 }
 
 type defaultStreamDecryptor struct {
-	DstBufferSize int
+	DstBufferSize int // Amount of data, which is stored in single chunk
 
 	CurrentDecBufferSize int
 	DecBuffer            []byte
@@ -352,9 +422,13 @@ type defaultStreamDecryptor struct {
 
 	// ChunkCounter is maintained in order to check chunks consistenct
 	ChunkCounter         uint64
-	ChunkCounterEncoding intEncoding
-	ChunkLengthEncoding  intEncoding
+	ChunkCounterEncoding IntEncoding
+	ChunkLengthEncoding  IntEncoding
 	MaxBufferSize        uint64 // Enabled only when chunk size is read from data.
+	Overhead             int    // Used only when chunk size is not read from data.
+
+	// FinalChunkRead is set once chunk marked as final has been read.
+	FinalChunkRead bool
 
 	Source io.Reader
 
@@ -366,7 +440,7 @@ func (asd *defaultStreamDecryptor) IsDone() bool {
 }
 
 func (asd *defaultStreamDecryptor) Close() (err error) {
-	if asd.ErrorCache == io.EOF {
+	if asd.ErrorCache == io.EOF || (asd.ErrorCache == nil && asd.FinalChunkRead && asd.CurrentDecBufferSize == 0) {
 		return nil
 	} else if asd.ErrorCache != nil {
 		err = asd.ErrorCache
@@ -378,6 +452,153 @@ func (asd *defaultStreamDecryptor) Close() (err error) {
 	return
 }
 
+// finish marks stream as ended. Data already read is returned first.
+func (asd *defaultStreamDecryptor) finish(sz int) (err error) {
+	if sz <= 0 {
+		// no data read in total so just return EOF
+		err = io.EOF
+	} else {
+		// some data has been already written
+		// so do not return error
+		// return it on next call to read
+		asd.ErrorCache = io.EOF
+	}
+	return
+}
+
+// checkStreamEnd makes sure that there is no more data in source after final chunk.
+// If stream has terminator and it was not read yet, then it's read.
+func checkStreamEnd(r io.Reader, lengthEncoding IntEncoding, terminatorRead bool) (err error) {
+	if lengthEncoding.IsValid() && !terminatorRead {
+		var terminator uint64
+		terminator, err = lengthEncoding.Decode(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// terminator is not trusted anyway
+			return uciph.ErrStreamTruncated
+		} else if err != nil {
+			return
+		}
+		if terminator != 0 {
+			return uciph.ErrStreamLogicEnd
+		}
+	}
+
+	var arr [1]byte
+	sz, err := io.ReadFull(r, arr[:])
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return
+	}
+	if sz > 0 {
+		err = uciph.ErrStreamLogicEnd
+	}
+	return
+}
+
+// readStreamFrame reads single encrypted chunk from r.
+// If lengths are not written, then chunk has fixedSize unless it's last one.
+// It returns nil frame when terminator is read or when source has ended at chunk boundary.
+func readStreamFrame(r io.Reader, lengthEncoding IntEncoding, maxSize uint64, fixedSize int) (frame []byte, err error) {
+	// 1. Read chunk length
+	// If not available fallback to fixedSize
+	var chunkLength int
+	if lengthEncoding.IsValid() {
+		var len uint64
+		len, err = lengthEncoding.Decode(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = uciph.ErrStreamTruncated
+			return
+		} else if err != nil {
+			return
+		}
+		if maxSize != 0 && len > maxSize {
+			err = uciph.ErrChunkTooBig
+			return
+		}
+		if len == 0 {
+			return
+		}
+
+		chunkLength = int(len)
+	} else {
+		chunkLength = fixedSize
+	}
+
+	// cache it for small sizes?
+	// using variable in struct
+
+	// 2. Allocate buffer for new chunk and read data into it
+	frame = make([]byte, chunkLength)
+	readSz, err := io.ReadFull(r, frame)
+	if err == io.EOF && !lengthEncoding.IsValid() {
+		// Source has ended at chunk boundary
+		return nil, nil
+	} else if err == io.ErrUnexpectedEOF && !lengthEncoding.IsValid() {
+		// Last chunk may be shorter
+		frame = frame[:readSz]
+		err = nil
+	} else if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, uciph.ErrStreamTruncated
+	} else if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// parseStreamChunk checks counter and flags of decrypted chunk and returns data stored in it.
+func parseStreamChunk(
+	chunk []byte,
+	counter uint64,
+	chunkSize int,
+	counterEncoding IntEncoding,
+) (data []byte, final bool, err error) {
+	// 1. Check chunk counter(if any)
+	if counterEncoding.IsValid() {
+		chunkCounter, chunkCounterSize := counterEncoding.DecodeBytes(chunk)
+		if chunkCounterSize < 0 {
+			err = uciph.ErrCiphertextInvalid
+			return
+		}
+
+		if chunkCounter != counter {
+			// Chunk counter mismatch!
+			err = uciph.ErrStreamChunksReordered
+			return
+		}
+
+		// Trim chunk, so it contians only useful data.
+		chunk = chunk[chunkCounterSize:]
+	}
+
+	// 2. Check chunk flags
+	if len(chunk) < 1 || chunk[0]&^(streamChunkFlagFinal|streamChunkFlagFlushed) != 0 ||
+		chunk[0] == streamChunkFlagFinal|streamChunkFlagFlushed {
+		err = uciph.ErrCiphertextInvalid
+		return
+	}
+	final = chunk[0]&streamChunkFlagFinal != 0
+	flushed := chunk[0]&streamChunkFlagFlushed != 0
+	data = chunk[1:]
+
+	// Only final and flushed chunks may be shorter than others
+	if (!final && !flushed && len(data) != chunkSize) || len(data) > chunkSize {
+		err = uciph.ErrStreamParamsMismatch
+		return
+	}
+	return
+}
+
+// fixedFrameSize returns size of encrypted chunk with given index, which is full.
+// It's used when lengths are not written.
+func fixedFrameSize(index uint64, chunkSize, overhead int, counterEncoding IntEncoding) int {
+	sz := chunkSize + overhead + 1
+	if counterEncoding.IsValid() {
+		sz += counterEncoding.Size(index)
+	}
+	return sz
+}
+
 func (asd *defaultStreamDecryptor) Read(buf []byte) (sz int, err error) {
 	if asd.ErrorCache != nil {
 		return 0, asd.ErrorCache
@@ -407,78 +628,52 @@ func (asd *defaultStreamDecryptor) Read(buf []byte) (sz int, err error) {
 
 	// Load data from Source in order to try to fill buf.
 	for len(buf) > 0 {
-		// 1. Read chunk length
-		// If not available fallback to asd.DstBufferSize
-		var chunkLength int
-		if asd.ChunkLengthEncoding.IsValid() {
-			var len uint64
-			len, err = asd.ChunkLengthEncoding.Decode(asd.Source)
-			if err != nil {
-				return
-			}
-			if asd.MaxBufferSize != 0 && len > asd.MaxBufferSize {
-				err = uciph.ErrChunkTooBig
-				return
-			}
-
-			chunkLength = int(len)
-		} else {
-			chunkLength = asd.DstBufferSize
+		// All data from final chunk has been returned already
+		if asd.FinalChunkRead {
+			err = asd.finish(sz)
+			return
 		}
 
-		// cache it for small sizes?
-		// using variable in struct
-
-		// 2. Allocate buffer for new chunk and read data into it
-		chunkBuffer := make([]byte, chunkLength)
-		_, err = io.ReadFull(asd.Source, chunkBuffer)
+		// 1. Read chunk
+		var chunkBuffer []byte
+		chunkBuffer, err = readStreamFrame(
+			asd.Source,
+			asd.ChunkLengthEncoding,
+			asd.MaxBufferSize,
+			fixedFrameSize(asd.ChunkCounter, asd.DstBufferSize, asd.Overhead, asd.ChunkCounterEncoding),
+		)
 		if err != nil {
 			return
 		}
+		if chunkBuffer == nil {
+			// Stream has ended, but final chunk has not been read yet.
+			// Someone has cut it, possibly appending terminator.
+			err = uciph.ErrStreamTruncated
+			return
+		}
 
-		// 3. Perform actual decryption
+		// 2. Perform actual decryption
 		chunkBuffer, err = asd.Decryptor.Decrypt(chunkBuffer, chunkBuffer[:0])
 		if err != nil {
 			return
 		}
 
-		// 4. Maintain chunk coutner(if any)
-		if asd.ChunkCounterEncoding.IsValid() {
-			var chunkCounter uint64
-			chunkCounter, err = asd.ChunkLengthEncoding.Decode(bytes.NewReader(chunkBuffer))
-			if err != nil {
-				return
-			}
-
-			chunkCounterSize := asd.ChunkLengthEncoding.Size(chunkCounter)
-
-			// It's terminator chunk.
-			if chunkCounter == 0 {
-				if len(chunkBuffer) != chunkCounterSize {
-					// Invalid chunk counter value! Finalize chunk must not contain any data!
-					err = errors.New("stream: TODO error invalid chunk counter value")
-					return
-				}
-				if sz <= 0 {
-					// no data read in total so just return EOF
-					err = io.EOF
-				} else {
-					// some data has been already written
-					// so do not return error
-					// return it on next call to read
-					asd.ErrorCache = io.EOF
-				}
-				return
-			}
+		// 3. Check chunk counter(if any) and flags
+		var final bool
+		chunkBuffer, final, err = parseStreamChunk(chunkBuffer, asd.ChunkCounter, asd.DstBufferSize, asd.ChunkCounterEncoding)
+		if err != nil {
+			return
+		}
+		asd.ChunkCounter++
 
-			if chunkCounter != asd.ChunkCounter {
-				// Chunk counter mismatch!
-				err = uciph.ErrStreamChunksReordered
+		// 4. Make sure that there is nothing after final chunk,
+		// before any data from it is returned
+		if final {
+			err = checkStreamEnd(asd.Source, asd.ChunkLengthEncoding, false)
+			if err != nil {
 				return
 			}
-
-			// Trim chunkBuffer, so it contians only useful data.
-			chunkBuffer = chunkBuffer[chunkCounterSize:]
+			asd.FinalChunkRead = true
 		}
 
 		// 5. Copy data into buffer and set data
@@ -502,23 +697,81 @@ func (asd *defaultStreamDecryptor) Read(buf []byte) (sz int, err error) {
 }
 
 // NewDefaultStreamEncryptor creates DefaultStreamEncryptor from encryptor and writer.
+// It uses default StreamOptions.
 func NewDefaultStreamEncryptor(e Encryptor, w io.Writer) StreamEncryptor {
-	dse := &defaultStreamEncryptor{
-		Encryptor:            e,
-		ChunkCounterEncoding: ByteVar,
-		Sink:                 w,
-		EncBuffer:            make([]byte, 1024*1024), // TODO(teaiwthsand): make this configurable
+	se, err := NewDefaultStreamEncryptorWithOptions(e, w, nil)
+	if err != nil {
+		panic(err) // default options are always valid
 	}
-	return dse
+	return se
 }
 
 // NewDefaultStreamDecryptor creates DefaultStreamDecryptor from decryptor and reader.
+// It uses default StreamOptions.
 func NewDefaultStreamDecryptor(d Decryptor, r io.Reader) StreamDecryptor {
-	dsd := &defaultStreamDecryptor{
+	sd, err := NewDefaultStreamDecryptorWithOptions(d, r, nil)
+	if err != nil {
+		panic(err) // default options are always valid
+	}
+	return sd
+}
+
+// NewDefaultStreamEncryptorWithOptions creates DefaultStreamEncryptor from encryptor and writer.
+// Format of stream is configured with StreamOptions from options.
+// Encryptor authenticates additional data it was created with along with each chunk.
+func NewDefaultStreamEncryptorWithOptions(e Encryptor, w io.Writer, options interface{}) (se StreamEncryptor, err error) {
+	so := GetStreamOptions(options)
+	err = so.Validate()
+	if err != nil {
+		return
+	}
+	so = so.withDefaults()
+
+	se = &defaultStreamEncryptor{
+		Encryptor:            e,
+		ChunkCounterEncoding: so.ChunkCounterEncoding,
+		ChunkLengthEncoding:  so.lengthEncoding(),
+		Sink:                 w,
+		DstBufferSize:        so.ChunkSize,
+		// leave some capacity, so encryption overhead fits in buffer
+		EncBuffer: make(
+			[]byte,
+			streamChunkPrefixSize+so.ChunkSize,
+			streamChunkPrefixSize+so.ChunkSize+defaultStreamMaxChunkOverhead,
+		),
+	}
+	return
+}
+
+// NewDefaultStreamDecryptorWithOptions creates DefaultStreamDecryptor from decryptor and reader.
+// Format of stream is configured with StreamOptions from options.
+// If stream was created with different StreamOptions, error is returned during reading.
+func NewDefaultStreamDecryptorWithOptions(d Decryptor, r io.Reader, options interface{}) (sd StreamDecryptor, err error) {
+	so := GetStreamOptions(options)
+	err = so.Validate()
+	if err != nil {
+		return
+	}
+	so = so.withDefaults()
+
+	var overhead int
+	if so.NoChunkLengths {
+		cd, ok := d.(ChunkDecryptor)
+		if !ok {
+			err = uciph.ErrStreamOptionsInvalid
+			return
+		}
+		overhead = cd.Overhead()
+	}
+
+	sd = &defaultStreamDecryptor{
 		Decryptor:            d,
-		ChunkCounterEncoding: ByteVar,
+		ChunkCounterEncoding: so.ChunkCounterEncoding,
+		ChunkLengthEncoding:  so.lengthEncoding(),
 		Source:               r,
-		MaxBufferSize:        1024 * 1024, // TODO(teawithsand): make this configurable value
+		DstBufferSize:        so.ChunkSize,
+		MaxBufferSize:        uint64(so.MaxChunkSize),
+		Overhead:             overhead,
 	}
-	return dsd
+	return
 }
@@ -0,0 +1,96 @@
+package enc
+
+import (
+	"io"
+
+	"github.com/teawithsand/uciph"
+)
+
+// seekerReaderAt makes io.ReadSeeker io.ReaderAt. It's not safe for concurrent use.
+type seekerReaderAt struct {
+	R    io.ReadSeeker
+	Base int64
+}
+
+func (r *seekerReaderAt) ReadAt(buf []byte, off int64) (sz int, err error) {
+	_, err = r.R.Seek(r.Base+off, io.SeekStart)
+	if err != nil {
+		return
+	}
+	sz, err = io.ReadFull(r.R, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return
+}
+
+// NewAppendStreamEncryptor opens stream created with default stream encryptor, which starts at current position
+// of given io.ReadWriteSeeker, so more data can be appended to it.
+// It has to be given same StreamOptions in options as original encryptor was.
+//
+// Final chunk is decrypted and verified with given decryptor, which has to be ChunkDecryptor.
+// Then its data and everything written to returned encryptor is encrypted starting from final chunk's index,
+// overwriting final chunk and terminator, so result is same as if stream was written in one go.
+// Other chunks are not read, so tampering with them is detected when stream is decrypted.
+//
+// Final chunk is encrypted again with its index, so encryptor has to be ChunkEncryptor and RandomNonceEncryptor,
+// which uses random nonces. Other encryptors, including counter nonce ones and wrappers, which do not state
+// that they use random nonces, are refused with uciph.ErrNonceReuse.
+// With counter nonces final chunk's nonce is given by its index, so encrypting it again with more data would reuse it.
+//
+// Because of that only raw default streams with random nonce encryptor can be appended to.
+// Streams with headers, like subkey, passphrase, multi recipient and envelope streams, always use counter nonces,
+// so they can't be appended to. Neither can streams with flushed chunks.
+func NewAppendStreamEncryptor(e Encryptor, d Decryptor, rws io.ReadWriteSeeker, options interface{}) (se StreamEncryptor, err error) {
+	if rne, ok := e.(RandomNonceEncryptor); !ok || !rne.RandomNonces() {
+		err = uciph.ErrNonceReuse
+		return
+	}
+	ce, ok := e.(ChunkEncryptor)
+	if !ok {
+		err = uciph.ErrResumeNotSupported
+		return
+	}
+
+	base, err := rws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	end, err := rws.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+
+	// 1. Find and verify final chunk
+	sd, err := NewSeekableStreamDecryptor(d, &seekerReaderAt{R: rws, Base: base}, end-base, options)
+	if err != nil {
+		return
+	}
+	ssd := sd.(*seekableStreamDecryptor)
+	finalIndex := ssd.ChunkCount - 1
+	data, err := ssd.readChunk(finalIndex)
+	if err != nil {
+		return
+	}
+	offset, _ := ssd.Layout.chunkOffset(finalIndex)
+
+	// 2. Continue encryption from final chunk
+	_, err = rws.Seek(base+int64(offset), io.SeekStart)
+	if err != nil {
+		return
+	}
+	res, err := ResumeStreamEncryptor(ce, rws, StreamEncryptorState{
+		StreamOptions: GetStreamOptions(options),
+		ChunkCounter:  finalIndex,
+		Offset:        offset,
+	})
+	if err != nil {
+		return
+	}
+	_, err = res.Write(data)
+	if err != nil {
+		return
+	}
+	se = res
+	return
+}
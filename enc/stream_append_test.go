@@ -0,0 +1,250 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/enc"
+)
+
+func makeTempFile(t *testing.T) *os.File {
+	f, err := ioutil.TempFile("", "uciph-append-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	return f
+}
+
+func TestAppendStreamEncryptor(t *testing.T) {
+	const chunkSize = 100
+	opts := copts.Options{}.WithStreamOptions(enc.StreamOptions{ChunkSize: chunkSize})
+	ek, dk := makeChaCha20Keys(t)
+
+	f := makeTempFile(t)
+	prefix := []byte("some header")
+	_, err := f.Write(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	write := func(se enc.StreamEncryptor, sz int) {
+		part := make([]byte, sz)
+		for i := range part {
+			part[i] = byte(len(data) + i)
+		}
+		data = append(data, part...)
+		_, err := se.Write(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = se.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := ek(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se, err := enc.NewDefaultStreamEncryptorWithOptions(e, f, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(se, 250)
+
+	for _, sz := range []int{0, 30, 500, 20} {
+		_, err = f.Seek(int64(len(prefix)), io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := ek(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := dk(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		se, err := enc.NewAppendStreamEncryptor(e, d, f, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(se, sz)
+	}
+
+	encrypted, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(encrypted, prefix) {
+		t.Fatal("Data before stream was changed")
+	}
+	encrypted = encrypted[len(prefix):]
+
+	decrypt := func(encrypted []byte) (res []byte, err error) {
+		d, err := dk(opts)
+		if err != nil {
+			return
+		}
+		sd, err := enc.NewDefaultStreamDecryptorWithOptions(d, bytes.NewReader(encrypted), opts)
+		if err != nil {
+			return
+		}
+		res, err = ioutil.ReadAll(sd)
+		if err != nil {
+			return
+		}
+		err = sd.Close()
+		return
+	}
+
+	res, err := decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, data) {
+		t.Fatal("Decrypted data differs")
+	}
+
+	// same structure as stream written in one go
+	e, err = ek(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := bytes.NewBuffer(nil)
+	se, err = enc.NewDefaultStreamEncryptorWithOptions(e, b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != len(encrypted) {
+		t.Fatalf("Appended stream has size %d, stream written at once %d", len(encrypted), b.Len())
+	}
+
+	// tampering before append point is detected
+	encrypted[10] ^= 1
+	_, err = decrypt(encrypted)
+	if err == nil {
+		t.Fatal("Expected error for tampered stream")
+	}
+}
+
+func TestAppendStreamEncryptorRefusesCounterNonces(t *testing.T) {
+	opts := copts.Options{}.WithNonceMode(enc.NonceModeCounter)
+	ek, dk := makeChaCha20Keys(t)
+	e, err := ek(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := dk(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := makeTempFile(t)
+	se := enc.NewDefaultStreamEncryptor(e, f)
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = enc.NewAppendStreamEncryptor(e, d, f, nil)
+	if !errors.Is(err, uciph.ErrNonceReuse) {
+		t.Fatalf("Expected ErrNonceReuse, got %v", err)
+	}
+}
+
+func TestAppendStreamEncryptorRefusesUnknownNonces(t *testing.T) {
+	ek, dk := makeChaCha20Keys(t)
+	e, err := ek(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := dk(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := makeTempFile(t)
+	se := enc.NewDefaultStreamEncryptor(e, f)
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// wrapper does not state how nonces are generated, even though wrapped encryptor uses random ones
+	for _, wrapped := range []enc.Encryptor{
+		enc.EncryptorFunc(e.Encrypt),
+		enc.BlankEncryptor(),
+	} {
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = enc.NewAppendStreamEncryptor(wrapped, d, f, nil)
+		if !errors.Is(err, uciph.ErrNonceReuse) {
+			t.Fatalf("Expected ErrNonceReuse, got %v", err)
+		}
+	}
+}
+
+func TestAppendStreamEncryptorVerifiesFinalChunk(t *testing.T) {
+	ek, dk := makeChaCha20Keys(t)
+	e, err := ek(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := makeTempFile(t)
+	se := enc.NewDefaultStreamEncryptor(e, f)
+	_, err = se.Write([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// damage final chunk
+	_, err = f.WriteAt([]byte{0xff}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err = ek(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := dk(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = enc.NewAppendStreamEncryptor(e, d, f, nil)
+	if err == nil {
+		t.Fatal("Expected error for damaged final chunk")
+	}
+}
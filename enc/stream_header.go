@@ -0,0 +1,284 @@
+package enc
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/rand"
+)
+
+// streamHeaderMagic is stored at the beginning of each stream header.
+var streamHeaderMagic = [4]byte{'U', 'C', 'S', 'H'}
+
+const streamHeaderVersion = 1
+
+// streamHeaderSize is size of encoded StreamHeader without salt.
+const streamHeaderSize = 4 + 1 + 1 + 1 + 1 + 1 + 1 + 4
+
+// StreamHeaderSaltSize is size of salt, which may be stored in StreamHeader.
+const StreamHeaderSaltSize = 32
+
+const (
+	streamHeaderFlagNoChunkLengths = 1 << iota
+	streamHeaderFlagSalt
+)
+
+// StreamHeader describes how stream was encrypted.
+// It's stored at the beginning of stream, so stream can be decrypted without knowing
+// parameters used to encrypt it.
+type StreamHeader struct {
+	Cipher    CipherID
+	NonceMode NonceMode
+
+	// StreamOptions used to create stream. MaxChunkSize is not stored.
+	StreamOptions StreamOptions
+
+	// Salt used to derive key of this stream from master key.
+	// It's either nil or has StreamHeaderSaltSize bytes.
+	Salt []byte
+}
+
+// MarshalBinary encodes StreamHeader.
+//
+// Format is:
+// magic(4 bytes), version(1 byte), cipher(1 byte), nonce mode(1 byte), flags(1 byte),
+// chunk length encoding(1 byte), chunk counter encoding(1 byte), chunk size(4 byte big endian)
+// and salt(StreamHeaderSaltSize bytes), if there is any.
+func (h *StreamHeader) MarshalBinary() (data []byte, err error) {
+	so := h.StreamOptions.withDefaults()
+	err = so.Validate()
+	if err != nil {
+		return
+	}
+	if uint64(so.ChunkSize) > uint64(^uint32(0)) || h.NonceMode > 0xff {
+		err = uciph.ErrStreamOptionsInvalid
+		return
+	}
+	if h.Salt != nil && len(h.Salt) != StreamHeaderSaltSize {
+		err = uciph.ErrStreamOptionsInvalid
+		return
+	}
+
+	var flags byte
+	if so.NoChunkLengths {
+		flags |= streamHeaderFlagNoChunkLengths
+	}
+	if h.Salt != nil {
+		flags |= streamHeaderFlagSalt
+	}
+
+	data = make([]byte, streamHeaderSize, streamHeaderSize+len(h.Salt))
+	copy(data[:4], streamHeaderMagic[:])
+	data[4] = streamHeaderVersion
+	data[5] = byte(h.Cipher)
+	data[6] = byte(h.NonceMode)
+	data[7] = flags
+	data[8] = byte(so.ChunkLengthEncoding)
+	data[9] = byte(so.ChunkCounterEncoding)
+	binary.BigEndian.PutUint32(data[10:], uint32(so.ChunkSize))
+	data = append(data, h.Salt...)
+	return
+}
+
+// UnmarshalBinary decodes StreamHeader encoded with MarshalBinary.
+func (h *StreamHeader) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < streamHeaderSize {
+		return uciph.ErrStreamHeaderInvalid
+	}
+	var magic [4]byte
+	copy(magic[:], data[:4])
+	if magic != streamHeaderMagic || data[4] != streamHeaderVersion {
+		return uciph.ErrStreamHeaderInvalid
+	}
+
+	nm := NonceMode(data[6])
+	if nm != NonceModeRandom && nm != NonceModeRandomUnsafe && nm != NonceModeCounter {
+		return uciph.ErrStreamHeaderInvalid
+	}
+
+	flags := data[7]
+	if flags&^(streamHeaderFlagNoChunkLengths|streamHeaderFlagSalt) != 0 {
+		return uciph.ErrStreamHeaderInvalid
+	}
+
+	var salt []byte
+	if flags&streamHeaderFlagSalt != 0 {
+		if len(data) != streamHeaderSize+StreamHeaderSaltSize {
+			return uciph.ErrStreamHeaderInvalid
+		}
+		salt = make([]byte, StreamHeaderSaltSize)
+		copy(salt, data[streamHeaderSize:])
+	} else if len(data) != streamHeaderSize {
+		return uciph.ErrStreamHeaderInvalid
+	}
+
+	so := StreamOptions{
+		ChunkSize:            int(binary.BigEndian.Uint32(data[10:])),
+		ChunkLengthEncoding:  IntEncoding(data[8]),
+		ChunkCounterEncoding: IntEncoding(data[9]),
+		NoChunkLengths:       flags&streamHeaderFlagNoChunkLengths != 0,
+	}
+	// zero chunk size would mean default one, which is never written
+	if so.ChunkSize <= 0 || so.Validate() != nil {
+		return uciph.ErrStreamHeaderInvalid
+	}
+
+	*h = StreamHeader{
+		Cipher:        CipherID(data[5]),
+		NonceMode:     nm,
+		StreamOptions: so,
+		Salt:          salt,
+	}
+	return
+}
+
+// ReadStreamHeader reads StreamHeader from the beginning of stream.
+func ReadStreamHeader(r io.Reader) (h StreamHeader, err error) {
+	var arr [streamHeaderSize + StreamHeaderSaltSize]byte
+	data := arr[:streamHeaderSize]
+	_, err = io.ReadFull(r, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = uciph.ErrStreamHeaderInvalid
+		return
+	} else if err != nil {
+		return
+	}
+
+	// Salt has to be read as well, if there is any
+	if data[7]&streamHeaderFlagSalt != 0 {
+		data = arr[:]
+		_, err = io.ReadFull(r, data[streamHeaderSize:])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = uciph.ErrStreamHeaderInvalid
+			return
+		} else if err != nil {
+			return
+		}
+	}
+
+	err = h.UnmarshalBinary(data)
+	return
+}
+
+// headerOptions overrides settings of options given by user with ones stored in stream header.
+type headerOptions struct {
+	Options   interface{}
+	Header    []byte
+	NonceMode NonceMode
+}
+
+func (o *headerOptions) GetNonceMode() NonceMode {
+	return o.NonceMode
+}
+
+func (o *headerOptions) GetRNG() rand.RNG {
+	return rand.GetRNG(o.Options)
+}
+
+// GetAdditionalData returns header followed by additional data given by user.
+// Header has known size, so there is no ambiguity.
+func (o *headerOptions) GetAdditionalData() []byte {
+	ad := GetAdditionalData(o.Options)
+	if len(ad) == 0 {
+		return o.Header
+	}
+	res := make([]byte, 0, len(o.Header)+len(ad))
+	res = append(res, o.Header...)
+	return append(res, ad...)
+}
+
+// NewHeaderStreamEncryptor creates StreamEncryptor, which writes StreamHeader before stream,
+// so it can be decrypted with NewHeaderStreamDecryptor without knowing parameters used to create it.
+//
+// Cipher has to identify algorithm of given key. NonceMode and StreamOptions are taken from options.
+// Header is authenticated as additional data of each chunk, so key should create AEAD based encryptors.
+// Additional data from options is authenticated along with header.
+// Header is written, when encryptor is created.
+func NewHeaderStreamEncryptor(ek EncKey, cipher CipherID, w io.Writer, options interface{}) (se StreamEncryptor, err error) {
+	return newHeaderStreamEncryptor(ek, StreamHeader{
+		Cipher:        cipher,
+		NonceMode:     GetNonceMode(options),
+		StreamOptions: GetStreamOptions(options).withDefaults(),
+	}, w, options)
+}
+
+func newHeaderStreamEncryptor(ek EncKey, h StreamHeader, w io.Writer, options interface{}) (se StreamEncryptor, err error) {
+	rawHeader, err := h.MarshalBinary()
+	if err != nil {
+		return
+	}
+
+	e, err := ek(&headerOptions{
+		Options:   options,
+		Header:    rawHeader,
+		NonceMode: h.NonceMode,
+	})
+	if err != nil {
+		return
+	}
+
+	se, err = NewDefaultStreamEncryptorWithOptions(e, w, h.StreamOptions)
+	if err != nil {
+		return
+	}
+
+	_, err = w.Write(rawHeader)
+	if err != nil {
+		se = nil
+		return
+	}
+	return
+}
+
+// NewHeaderStreamDecryptor reads StreamHeader from reader and creates StreamDecryptor
+// for the rest of stream, which uses settings stored in header.
+//
+// Keys maps ciphers, which are allowed to be used, to keys, which should be used to decrypt stream.
+// MaxChunkSize of StreamOptions from options limits chunk size, which may be stored in header.
+// Streams with salt in header have to be decrypted with NewSubkeyStreamDecryptor.
+func NewHeaderStreamDecryptor(keys map[CipherID]DecKey, r io.Reader, options interface{}) (sd StreamDecryptor, err error) {
+	h, err := ReadStreamHeader(r)
+	if err != nil {
+		return
+	}
+	if h.Salt != nil {
+		err = uciph.ErrStreamParamsMismatch
+		return
+	}
+
+	dk, ok := keys[h.Cipher]
+	if !ok || dk == nil {
+		err = uciph.ErrCipherNotAllowed
+		return
+	}
+
+	return newHeaderStreamDecryptor(dk, h, r, options)
+}
+
+func newHeaderStreamDecryptor(dk DecKey, h StreamHeader, r io.Reader, options interface{}) (sd StreamDecryptor, err error) {
+	maxChunkSize := GetStreamOptions(options).withDefaults().MaxChunkSize
+	if h.StreamOptions.ChunkSize > maxChunkSize-defaultStreamMaxChunkOverhead {
+		err = uciph.ErrChunkTooBig
+		return
+	}
+
+	rawHeader, err := h.MarshalBinary()
+	if err != nil {
+		return
+	}
+
+	d, err := dk(&headerOptions{
+		Options:   options,
+		Header:    rawHeader,
+		NonceMode: h.NonceMode,
+	})
+	if err != nil {
+		return
+	}
+
+	so := h.StreamOptions
+	so.MaxChunkSize = maxChunkSize
+	sd, err = NewDefaultStreamDecryptorWithOptions(d, r, so)
+	return
+}
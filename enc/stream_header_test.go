@@ -0,0 +1,131 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/enc"
+)
+
+func makeChaCha20Keys(t *testing.T) (enc.EncKey, enc.DecKey) {
+	rawKey, err := enc.ChaCha20Poly1305Keygen(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := enc.ParseChaCha20Poly1305EncKey(rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dk, err := enc.ParseChaCha20Poly1305DecKey(rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ek, dk
+}
+
+func TestHeaderStreamED(t *testing.T) {
+	for name, opts := range map[string]copts.Options{
+		"RandomNonce":  copts.Options{}.WithNonceMode(enc.NonceModeRandom),
+		"CounterNonce": copts.Options{}.WithNonceMode(enc.NonceModeCounter),
+		"SmallChunks": copts.Options{}.WithNonceMode(enc.NonceModeCounter).WithStreamOptions(enc.StreamOptions{
+			ChunkSize:            1000,
+			ChunkCounterEncoding: enc.Byte4,
+		}),
+	} {
+		opts := opts
+		t.Run(name, func(t *testing.T) {
+			ek, dk := makeChaCha20Keys(t)
+			ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+				se, err := enc.NewHeaderStreamEncryptor(ek, enc.CipherChaCha20Poly1305, w, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return se
+			}, func(r io.Reader) enc.StreamDecryptor {
+				// decryptor does not need any options
+				sd, err := enc.NewHeaderStreamDecryptor(map[enc.CipherID]enc.DecKey{
+					enc.CipherChaCha20Poly1305: dk,
+				}, r, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return sd
+			})
+		})
+	}
+}
+
+func TestHeaderStreamDecryptorRejectsInvalidHeader(t *testing.T) {
+	ek, dk := makeChaCha20Keys(t)
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewHeaderStreamEncryptor(ek, enc.CipherChaCha20Poly1305, b, copts.Options{}.WithNonceMode(enc.NonceModeRandom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write([]byte("Hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := b.Bytes()
+
+	decrypt := func(data []byte, keys map[enc.CipherID]enc.DecKey) (err error) {
+		sd, err := enc.NewHeaderStreamDecryptor(keys, bytes.NewReader(data), nil)
+		if err != nil {
+			return
+		}
+		_, err = ioutil.ReadAll(sd)
+		return
+	}
+
+	t.Run("CipherNotAllowed", func(t *testing.T) {
+		err := decrypt(encrypted, map[enc.CipherID]enc.DecKey{
+			enc.CipherXChaCha20Poly1305: dk,
+		})
+		if !errors.Is(err, uciph.ErrCipherNotAllowed) {
+			t.Fatalf("Expected ErrCipherNotAllowed, got %v", err)
+		}
+	})
+
+	t.Run("InvalidMagic", func(t *testing.T) {
+		tampered := append([]byte{}, encrypted...)
+		tampered[0] ^= 1
+		err := decrypt(tampered, map[enc.CipherID]enc.DecKey{
+			enc.CipherChaCha20Poly1305: dk,
+		})
+		if !errors.Is(err, uciph.ErrStreamHeaderInvalid) {
+			t.Fatalf("Expected ErrStreamHeaderInvalid, got %v", err)
+		}
+	})
+
+	t.Run("HeaderAuthenticated", func(t *testing.T) {
+		// Both nonce modes create same decryptor, so only authentication of header
+		// is able to detect this change.
+		h, err := enc.ReadStreamHeader(bytes.NewReader(encrypted))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.NonceMode = enc.NonceModeRandomUnsafe
+		rawHeader, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tampered := append(rawHeader, encrypted[len(rawHeader):]...)
+		err = decrypt(tampered, map[enc.CipherID]enc.DecKey{
+			enc.CipherChaCha20Poly1305: dk,
+		})
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	})
+}
@@ -0,0 +1,276 @@
+package enc
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/rand"
+)
+
+// STREAM construction by Hoang, Reyhanitabar, Rogaway and Vizár.
+// Nonce of each chunk is: random prefix || chunk counter(4 byte big endian) || final chunk flag(1 byte).
+// Prefix is written at the beginning of stream.
+// Chunks are not prefixed with their lengths. All chunks except last one contain exactly ChunkSize bytes of data.
+// Final chunk contains less than ChunkSize bytes of data, so it can be found without any lookahead.
+
+// onlineStreamMinNonceSize is min nonce size of AEAD, which may be used with STREAM.
+// It leaves 7 byte random prefix, which is used by most of implementations.
+const onlineStreamMinNonceSize = 7 + 4 + 1
+
+var errNonceTooShort = errors.New("uciph/enc: Nonce of given AEAD is too short to be used with STREAM")
+
+// setOnlineStreamNonce writes chunk counter and final flag into nonce, which already contains prefix.
+func setOnlineStreamNonce(nonce []byte, counter uint64, final bool) (err error) {
+	if counter > uint64(^uint32(0)) {
+		return uciph.ErrTooManyChunksEncrypted
+	}
+	binary.BigEndian.PutUint32(nonce[len(nonce)-5:], uint32(counter))
+	if final {
+		nonce[len(nonce)-1] = 1
+	} else {
+		nonce[len(nonce)-1] = 0
+	}
+	return
+}
+
+type onlineStreamEncryptor struct {
+	AEAD           cipher.AEAD
+	Nonce          []byte
+	AdditionalData []byte
+
+	ChunkCounter uint64
+
+	// Buffer has capacity for overhead, so chunks are sealed in place.
+	Buffer            []byte
+	CurrentBufferSize int
+
+	Sink io.Writer
+
+	ErrorCache error
+}
+
+func (ose *onlineStreamEncryptor) writeChunk(final bool) (err error) {
+	err = setOnlineStreamNonce(ose.Nonce, ose.ChunkCounter, final)
+	if err != nil {
+		return
+	}
+
+	res := ose.AEAD.Seal(ose.Buffer[:0], ose.Nonce, ose.Buffer[:ose.CurrentBufferSize], ose.AdditionalData)
+	ose.ChunkCounter++
+	ose.CurrentBufferSize = 0
+
+	_, err = ose.Sink.Write(res)
+	return
+}
+
+func (ose *onlineStreamEncryptor) Write(data []byte) (sz int, err error) {
+	if ose.ErrorCache != nil {
+		return 0, ose.ErrorCache
+	}
+	defer func() {
+		if err != nil {
+			ose.ErrorCache = err
+		}
+	}()
+	sz = len(data)
+
+	for len(data) > 0 {
+		copiedSz := copy(ose.Buffer[ose.CurrentBufferSize:], data)
+		ose.CurrentBufferSize += copiedSz
+		data = data[copiedSz:]
+
+		// Full chunk is never final one, since final chunk is written on close.
+		if ose.CurrentBufferSize == len(ose.Buffer) {
+			err = ose.writeChunk(false)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (ose *onlineStreamEncryptor) Close() (err error) {
+	if ose.ErrorCache != nil {
+		return ose.ErrorCache
+	}
+	defer func() {
+		if err != nil {
+			ose.ErrorCache = err
+		} else {
+			ose.ErrorCache = errStreamEncryptorClosed
+		}
+	}()
+
+	// Final chunk is always written, even if it contains no data.
+	err = ose.writeChunk(true)
+	return
+}
+
+type onlineStreamDecryptor struct {
+	AEAD           cipher.AEAD
+	Nonce          []byte
+	AdditionalData []byte
+
+	ChunkCounter uint64
+
+	ChunkBuffer []byte // has size of full encrypted chunk
+	DecBuffer   []byte // decrypted data, which was not returned yet
+	Finished    bool
+
+	Source io.Reader
+
+	ErrorCache error
+}
+
+func (osd *onlineStreamDecryptor) readChunk() (err error) {
+	sz, err := io.ReadFull(osd.Source, osd.ChunkBuffer)
+	final := false
+	if err == io.ErrUnexpectedEOF {
+		// Only final chunk is shorter than others
+		final = true
+		err = nil
+	} else if err == io.EOF {
+		// Stream has been cut at chunk boundary
+		return uciph.ErrStreamTruncated
+	} else if err != nil {
+		return
+	}
+
+	err = setOnlineStreamNonce(osd.Nonce, osd.ChunkCounter, final)
+	if err != nil {
+		return
+	}
+
+	osd.DecBuffer, err = osd.AEAD.Open(osd.ChunkBuffer[:0], osd.Nonce, osd.ChunkBuffer[:sz], osd.AdditionalData)
+	if err != nil {
+		return
+	}
+	osd.ChunkCounter++
+	osd.Finished = final
+	return
+}
+
+func (osd *onlineStreamDecryptor) Read(buf []byte) (sz int, err error) {
+	if osd.ErrorCache != nil {
+		return 0, osd.ErrorCache
+	}
+	defer func() {
+		if err != nil {
+			osd.ErrorCache = err
+		}
+	}()
+
+	for len(buf) > 0 {
+		if len(osd.DecBuffer) == 0 {
+			if osd.Finished {
+				if sz == 0 {
+					err = io.EOF
+				}
+				return
+			}
+
+			err = osd.readChunk()
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		copiedSz := copy(buf, osd.DecBuffer)
+		osd.DecBuffer = osd.DecBuffer[copiedSz:]
+		buf = buf[copiedSz:]
+		sz += copiedSz
+	}
+	return
+}
+
+func (osd *onlineStreamDecryptor) Close() (err error) {
+	if osd.ErrorCache == io.EOF || (osd.ErrorCache == nil && osd.Finished && len(osd.DecBuffer) == 0) {
+		return nil
+	} else if osd.ErrorCache != nil {
+		return osd.ErrorCache
+	}
+	osd.ErrorCache = uciph.ErrStreamTruncated
+	return osd.ErrorCache
+}
+
+// NewSTREAMEncryptor creates StreamEncryptor, which uses STREAM construction on top of given AEAD.
+// AEAD may be created with NewChaCha20Poly1305AEAD, NewXChaCha20Poly1305AEAD, NewAESGCMAEAD or CipherID.NewAEAD.
+// Its nonce has to be at least 12 bytes long.
+//
+// Only ChunkSize is used from StreamOptions. RNG from options is used to generate nonce prefix,
+// which is written, when encryptor is created. Additional data from options is authenticated with each chunk.
+func NewSTREAMEncryptor(aead cipher.AEAD, w io.Writer, options interface{}) (se StreamEncryptor, err error) {
+	if aead.NonceSize() < onlineStreamMinNonceSize {
+		err = errNonceTooShort
+		return
+	}
+
+	so := GetStreamOptions(options)
+	err = so.Validate()
+	if err != nil {
+		return
+	}
+	so = so.withDefaults()
+
+	nonce := make([]byte, aead.NonceSize())
+	prefix := nonce[:len(nonce)-5]
+	_, err = io.ReadFull(rand.GetRNG(options), prefix)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(prefix)
+	if err != nil {
+		return
+	}
+
+	se = &onlineStreamEncryptor{
+		AEAD:           aead,
+		Nonce:          nonce,
+		AdditionalData: GetAdditionalData(options),
+		Buffer:         make([]byte, so.ChunkSize, so.ChunkSize+aead.Overhead()),
+		Sink:           w,
+	}
+	return
+}
+
+// NewSTREAMDecryptor creates StreamDecryptor, which decrypts stream created with NewSTREAMEncryptor.
+// It has to be given same AEAD and ChunkSize as encryptor was.
+//
+// Nonce prefix is read, when decryptor is created.
+// Reordering, truncation and appending data are all detected, since chunk nonces depend on their positions.
+func NewSTREAMDecryptor(aead cipher.AEAD, r io.Reader, options interface{}) (sd StreamDecryptor, err error) {
+	if aead.NonceSize() < onlineStreamMinNonceSize {
+		err = errNonceTooShort
+		return
+	}
+
+	so := GetStreamOptions(options)
+	err = so.Validate()
+	if err != nil {
+		return
+	}
+	so = so.withDefaults()
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(r, nonce[:len(nonce)-5])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = uciph.ErrStreamTruncated
+		return
+	} else if err != nil {
+		return
+	}
+
+	sd = &onlineStreamDecryptor{
+		AEAD:           aead,
+		Nonce:          nonce,
+		AdditionalData: GetAdditionalData(options),
+		ChunkBuffer:    make([]byte, so.ChunkSize+aead.Overhead()),
+		Source:         r,
+	}
+	return
+}
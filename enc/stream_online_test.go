@@ -0,0 +1,147 @@
+package enc_test
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/enc"
+)
+
+func makeTestAEAD(t *testing.T, id enc.CipherID) cipher.AEAD {
+	var rawKey []byte
+	var err error
+	switch id {
+	case enc.CipherAES128GCM:
+		rawKey, err = enc.AESKeygen(nil, enc.AES128, nil)
+	case enc.CipherAES192GCM:
+		rawKey, err = enc.AESKeygen(nil, enc.AES192, nil)
+	case enc.CipherAES256GCM:
+		rawKey, err = enc.AESKeygen(nil, enc.AES256, nil)
+	default:
+		rawKey, err = enc.ChaCha20Poly1305Keygen(nil, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := id.NewAEAD(rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+func TestSTREAMED(t *testing.T) {
+	for _, id := range []enc.CipherID{
+		enc.CipherChaCha20Poly1305,
+		enc.CipherXChaCha20Poly1305,
+		enc.CipherAES128GCM,
+		enc.CipherAES256GCM,
+	} {
+		id := id
+		t.Run(id.String(), func(t *testing.T) {
+			aead := makeTestAEAD(t, id)
+			opts := copts.Options{}.WithStreamOptions(enc.StreamOptions{
+				ChunkSize: 1000,
+			})
+			ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+				se, err := enc.NewSTREAMEncryptor(aead, w, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return se
+			}, func(r io.Reader) enc.StreamDecryptor {
+				sd, err := enc.NewSTREAMDecryptor(aead, r, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return sd
+			})
+		})
+	}
+}
+
+func TestSTREAMDetectsTampering(t *testing.T) {
+	const chunkSize = 1000
+	opts := copts.Options{}.WithStreamOptions(enc.StreamOptions{
+		ChunkSize: chunkSize,
+	})
+	aead := makeTestAEAD(t, enc.CipherChaCha20Poly1305)
+
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewSTREAMEncryptor(aead, b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(make([]byte, chunkSize*3+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := b.Bytes()
+
+	prefixSize := aead.NonceSize() - 5
+	encChunkSize := chunkSize + aead.Overhead()
+
+	decrypt := func(data []byte) (err error) {
+		sd, err := enc.NewSTREAMDecryptor(aead, bytes.NewReader(data), opts)
+		if err != nil {
+			return
+		}
+		_, err = ioutil.ReadAll(sd)
+		if err != nil {
+			return
+		}
+		return sd.Close()
+	}
+
+	err = decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("TruncatedAtChunkBoundary", func(t *testing.T) {
+		err := decrypt(encrypted[:prefixSize+encChunkSize*2])
+		if !errors.Is(err, uciph.ErrStreamTruncated) {
+			t.Fatalf("Expected ErrStreamTruncated, got %v", err)
+		}
+	})
+
+	t.Run("FinalChunkRemoved", func(t *testing.T) {
+		// Last full chunk has to be decrypted as final one, which fails.
+		err := decrypt(encrypted[:prefixSize+encChunkSize*3-1])
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	})
+
+	t.Run("Reordered", func(t *testing.T) {
+		reordered := append([]byte{}, encrypted...)
+		first := reordered[prefixSize : prefixSize+encChunkSize]
+		second := reordered[prefixSize+encChunkSize : prefixSize+encChunkSize*2]
+		tmp := append([]byte{}, first...)
+		copy(first, second)
+		copy(second, tmp)
+
+		err := decrypt(reordered)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	})
+
+	t.Run("Appended", func(t *testing.T) {
+		err := decrypt(append(append([]byte{}, encrypted...), 0))
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	})
+}
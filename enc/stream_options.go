@@ -0,0 +1,129 @@
+package enc
+
+import (
+	"runtime"
+
+	"github.com/teawithsand/uciph"
+)
+
+// StreamOptions configures format of default stream.
+// Zero value is valid and describes default format.
+//
+// Both encryptor and decryptor have to be given same StreamOptions.
+type StreamOptions struct {
+	// ChunkSize is amount of data stored in single chunk.
+	// Encryptor buffers that much data. Zero means 1MiB.
+	ChunkSize int
+
+	// MaxChunkSize is max size of encrypted chunk, which is accepted by decryptor.
+	// Zero means ChunkSize plus 1KiB for encryption overhead.
+	MaxChunkSize int
+
+	// ChunkLengthEncoding is encoding used for lengths of chunks.
+	ChunkLengthEncoding IntEncoding
+
+	// ChunkCounterEncoding is encoding used for chunk counter, which is stored in each chunk
+	// in order to detect reordering.
+	ChunkCounterEncoding IntEncoding
+
+	// NoChunkLengths disables writing lengths of chunks.
+	// Decryptor has to be ChunkDecryptor then, since it has to know size of encrypted chunk.
+	// It also makes stream unable to be terminated, so truncation at chunk boundary can't be detected.
+	NoChunkLengths bool
+}
+
+// StreamOptionsProvider is kind of options, which provides StreamOptions.
+type StreamOptionsProvider interface {
+	GetStreamOptions() StreamOptions
+}
+
+// GetStreamOptions gets stream options from specified options.
+// If options do not provide any, zero StreamOptions are returned.
+func GetStreamOptions(options interface{}) (so StreamOptions) {
+	if sopts, ok := options.(StreamOptionsProvider); ok {
+		so = sopts.GetStreamOptions()
+	}
+	return
+}
+
+// GetStreamOptions makes StreamOptions StreamOptionsProvider, so they can be used as options.
+func (so StreamOptions) GetStreamOptions() StreamOptions {
+	return so
+}
+
+// withDefaults fills zero values with default ones.
+func (so StreamOptions) withDefaults() StreamOptions {
+	if so.ChunkSize == 0 {
+		so.ChunkSize = defaultStreamChunkSize
+	}
+	if so.MaxChunkSize == 0 {
+		so.MaxChunkSize = so.ChunkSize + defaultStreamMaxChunkOverhead
+	}
+	return so
+}
+
+// lengthEncoding returns encoding of chunk length or noIntEncoding if lengths are not written.
+func (so StreamOptions) lengthEncoding() IntEncoding {
+	if so.NoChunkLengths {
+		return noIntEncoding
+	}
+	return so.ChunkLengthEncoding
+}
+
+// Validate checks if stream with these options can be created.
+func (so StreamOptions) Validate() (err error) {
+	so = so.withDefaults()
+	if so.ChunkSize < 0 || so.MaxChunkSize < so.ChunkSize {
+		return uciph.ErrStreamOptionsInvalid
+	}
+	if !so.ChunkCounterEncoding.IsValid() || !so.ChunkLengthEncoding.IsValid() {
+		return uciph.ErrStreamOptionsInvalid
+	}
+	if !so.NoChunkLengths && so.ChunkLengthEncoding.Size(uint64(so.MaxChunkSize)) < 0 {
+		return uciph.ErrStreamOptionsInvalid
+	}
+	return
+}
+
+// ParallelOptions configures parallel stream encryptors and decryptors.
+// They do not affect format of stream, so zero value is always valid.
+type ParallelOptions struct {
+	// Workers is count of goroutines, which encrypt or decrypt chunks.
+	// Zero means runtime.NumCPU().
+	Workers int
+
+	// MaxInFlightChunks limits count of chunks, which are processed or wait to be written or read at once.
+	// It bounds memory used by stream to about MaxInFlightChunks * ChunkSize.
+	// Zero means 2 * Workers.
+	MaxInFlightChunks int
+}
+
+// ParallelOptionsProvider is kind of options, which provides ParallelOptions.
+type ParallelOptionsProvider interface {
+	GetParallelOptions() ParallelOptions
+}
+
+// GetParallelOptions gets parallel options from specified options.
+// If options do not provide any, zero ParallelOptions are returned.
+func GetParallelOptions(options interface{}) (po ParallelOptions) {
+	if popts, ok := options.(ParallelOptionsProvider); ok {
+		po = popts.GetParallelOptions()
+	}
+	return
+}
+
+// GetParallelOptions makes ParallelOptions ParallelOptionsProvider, so they can be used as options.
+func (po ParallelOptions) GetParallelOptions() ParallelOptions {
+	return po
+}
+
+// withDefaults fills zero values with default ones.
+func (po ParallelOptions) withDefaults() ParallelOptions {
+	if po.Workers <= 0 {
+		po.Workers = runtime.NumCPU()
+	}
+	if po.MaxInFlightChunks <= 0 {
+		po.MaxInFlightChunks = 2 * po.Workers
+	}
+	return po
+}
@@ -0,0 +1,420 @@
+package enc
+
+import (
+	"io"
+
+	"github.com/teawithsand/uciph"
+)
+
+// parallelJob is single chunk, which is encrypted or decrypted by worker.
+type parallelJob struct {
+	Index  uint64
+	Buffer []byte // whole buffer, which may be reused once job is done
+	In     []byte
+
+	Result []byte
+	Err    error
+	Done   chan struct{}
+}
+
+// startParallelWorkers starts workers, which run fn for each job sent to returned channel.
+// Channel has to be closed in order to stop them.
+func startParallelWorkers(po ParallelOptions, fn func(job *parallelJob)) chan<- *parallelJob {
+	jobs := make(chan *parallelJob, po.MaxInFlightChunks)
+	for i := 0; i < po.Workers; i++ {
+		go func() {
+			for job := range jobs {
+				fn(job)
+				close(job.Done)
+			}
+		}()
+	}
+	return jobs
+}
+
+type parallelStreamEncryptor struct {
+	DstBufferSize int // Amount of data, which is stored in single chunk
+
+	CurrentEncBufferSize int
+	// EncBuffer has same layout as defaultStreamEncryptor's one.
+	EncBuffer   []byte
+	FreeBuffers [][]byte
+
+	ChunkCounter         uint64
+	ChunkCounterEncoding IntEncoding
+	ChunkLengthEncoding  IntEncoding
+
+	MaxInFlightChunks int
+	Jobs              chan<- *parallelJob
+	InFlight          []*parallelJob // in order of chunks
+
+	Sink io.Writer
+
+	ErrorCache error
+}
+
+func (pse *parallelStreamEncryptor) newBuffer() (buf []byte) {
+	if len(pse.FreeBuffers) > 0 {
+		buf = pse.FreeBuffers[len(pse.FreeBuffers)-1]
+		pse.FreeBuffers = pse.FreeBuffers[:len(pse.FreeBuffers)-1]
+		return
+	}
+	return make(
+		[]byte,
+		streamChunkPrefixSize+pse.DstBufferSize,
+		streamChunkPrefixSize+pse.DstBufferSize+defaultStreamMaxChunkOverhead,
+	)
+}
+
+// writeOldest waits until oldest chunk in flight is encrypted and writes it to sink.
+func (pse *parallelStreamEncryptor) writeOldest() (err error) {
+	job := pse.InFlight[0]
+	<-job.Done
+	pse.InFlight[0] = nil
+	pse.InFlight = pse.InFlight[1:]
+	if job.Err != nil {
+		return job.Err
+	}
+
+	err = writeStreamFrame(pse.Sink, job.Result, pse.ChunkLengthEncoding)
+	if err != nil {
+		return
+	}
+	pse.FreeBuffers = append(pse.FreeBuffers, job.Buffer)
+	return
+}
+
+// submitChunk sends data stored in EncBuffer to workers.
+func (pse *parallelStreamEncryptor) submitChunk(final bool) (err error) {
+	var flags byte
+	if final {
+		flags = streamChunkFlagFinal
+	}
+	chunk, err := makeStreamChunk(pse.EncBuffer, pse.CurrentEncBufferSize, pse.ChunkCounter, flags, pse.ChunkCounterEncoding)
+	if err != nil {
+		return
+	}
+
+	for len(pse.InFlight) >= pse.MaxInFlightChunks {
+		err = pse.writeOldest()
+		if err != nil {
+			return
+		}
+	}
+
+	job := &parallelJob{
+		Index:  pse.ChunkCounter,
+		Buffer: pse.EncBuffer,
+		In:     chunk,
+		Done:   make(chan struct{}),
+	}
+	pse.Jobs <- job
+	pse.InFlight = append(pse.InFlight, job)
+
+	pse.ChunkCounter++
+	pse.EncBuffer = pse.newBuffer()
+	pse.CurrentEncBufferSize = 0
+	return
+}
+
+// stop stops workers. Chunks in flight are discarded.
+func (pse *parallelStreamEncryptor) stop() {
+	if pse.Jobs != nil {
+		close(pse.Jobs)
+		pse.Jobs = nil
+	}
+}
+
+func (pse *parallelStreamEncryptor) Write(data []byte) (sz int, err error) {
+	if pse.ErrorCache != nil {
+		return 0, pse.ErrorCache
+	}
+	defer func() {
+		if err != nil {
+			pse.ErrorCache = err
+			pse.stop()
+		}
+	}()
+	sz = len(data)
+
+	for len(data) > 0 {
+		copiedSz := copy(
+			pse.EncBuffer[streamChunkPrefixSize+pse.CurrentEncBufferSize:streamChunkPrefixSize+pse.DstBufferSize],
+			data,
+		)
+		pse.CurrentEncBufferSize += copiedSz
+		data = data[copiedSz:]
+
+		// It's never final chunk, since final chunk is written on close.
+		if pse.CurrentEncBufferSize == pse.DstBufferSize {
+			err = pse.submitChunk(false)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Close writes all chunks in flight and stops workers.
+// Workers are stopped as well when writing fails.
+func (pse *parallelStreamEncryptor) Close() (err error) {
+	defer pse.stop()
+	if pse.ErrorCache != nil {
+		return pse.ErrorCache
+	}
+	defer func() {
+		if err != nil {
+			pse.ErrorCache = err
+		} else {
+			pse.ErrorCache = errStreamEncryptorClosed
+		}
+	}()
+
+	err = pse.submitChunk(true)
+	if err != nil {
+		return
+	}
+	for len(pse.InFlight) > 0 {
+		err = pse.writeOldest()
+		if err != nil {
+			return
+		}
+	}
+
+	err = writeStreamTerminator(pse.Sink, pse.ChunkLengthEncoding)
+	return
+}
+
+type parallelStreamDecryptor struct {
+	DstBufferSize int // Amount of data, which is stored in single chunk
+
+	ChunkCounter         uint64 // index of next chunk, which will be read from source
+	ChunkCounterEncoding IntEncoding
+	ChunkLengthEncoding  IntEncoding
+	MaxBufferSize        uint64
+	Overhead             int
+
+	MaxInFlightChunks int
+	Jobs              chan<- *parallelJob
+	InFlight          []*parallelJob // in order of chunks
+
+	// SourceDone is set once there are no more chunks in source.
+	SourceDone     bool
+	TerminatorRead bool
+	// SourceError is error, which occurred while reading chunks ahead.
+	// It's returned once all chunks read before are returned.
+	SourceError error
+
+	DecBuffer      []byte
+	FinalChunkRead bool
+
+	Source io.Reader
+
+	ErrorCache error
+}
+
+// fill reads chunks from source and sends them to workers until there are MaxInFlightChunks chunks in flight.
+func (psd *parallelStreamDecryptor) fill() {
+	for !psd.SourceDone && psd.SourceError == nil && len(psd.InFlight) < psd.MaxInFlightChunks {
+		fixedSize := fixedFrameSize(psd.ChunkCounter, psd.DstBufferSize, psd.Overhead, psd.ChunkCounterEncoding)
+		frame, err := readStreamFrame(psd.Source, psd.ChunkLengthEncoding, psd.MaxBufferSize, fixedSize)
+		if err != nil {
+			psd.SourceError = err
+			return
+		}
+		if frame == nil {
+			psd.SourceDone = true
+			psd.TerminatorRead = psd.ChunkLengthEncoding.IsValid()
+			return
+		}
+		if !psd.ChunkLengthEncoding.IsValid() && len(frame) < fixedSize {
+			// Only last chunk may be shorter
+			psd.SourceDone = true
+		}
+
+		job := &parallelJob{
+			Index:  psd.ChunkCounter,
+			Buffer: frame,
+			In:     frame,
+			Done:   make(chan struct{}),
+		}
+		psd.Jobs <- job
+		psd.InFlight = append(psd.InFlight, job)
+		psd.ChunkCounter++
+	}
+}
+
+// nextChunk returns data of next chunk in stream.
+func (psd *parallelStreamDecryptor) nextChunk() (data []byte, err error) {
+	psd.fill()
+	if len(psd.InFlight) == 0 {
+		if psd.SourceError != nil {
+			return nil, psd.SourceError
+		}
+		// Stream has ended, but final chunk has not been read yet.
+		return nil, uciph.ErrStreamTruncated
+	}
+
+	job := psd.InFlight[0]
+	<-job.Done
+	psd.InFlight[0] = nil
+	psd.InFlight = psd.InFlight[1:]
+	if job.Err != nil {
+		return nil, job.Err
+	}
+
+	data, final, err := parseStreamChunk(job.Result, job.Index, psd.DstBufferSize, psd.ChunkCounterEncoding)
+	if err != nil {
+		return
+	}
+
+	// Make sure that there is nothing after final chunk,
+	// before any data from it is returned
+	if final {
+		if len(psd.InFlight) > 0 {
+			return nil, uciph.ErrStreamLogicEnd
+		}
+		if psd.SourceError != nil {
+			return nil, psd.SourceError
+		}
+		err = checkStreamEnd(psd.Source, psd.ChunkLengthEncoding, psd.TerminatorRead)
+		if err != nil {
+			return
+		}
+		psd.FinalChunkRead = true
+
+		// nothing is left to decrypt, so workers are not needed anymore
+		psd.stop()
+	}
+	return
+}
+
+// stop stops workers. Chunks in flight are discarded.
+func (psd *parallelStreamDecryptor) stop() {
+	if psd.Jobs != nil {
+		close(psd.Jobs)
+		psd.Jobs = nil
+	}
+}
+
+func (psd *parallelStreamDecryptor) Read(buf []byte) (sz int, err error) {
+	if psd.ErrorCache != nil {
+		return 0, psd.ErrorCache
+	}
+	defer func() {
+		if err != nil {
+			psd.ErrorCache = err
+			psd.stop()
+		}
+	}()
+
+	for len(buf) > 0 {
+		if len(psd.DecBuffer) == 0 {
+			if psd.FinalChunkRead {
+				if sz == 0 {
+					err = io.EOF
+				}
+				return
+			}
+
+			psd.DecBuffer, err = psd.nextChunk()
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		copiedSz := copy(buf, psd.DecBuffer)
+		psd.DecBuffer = psd.DecBuffer[copiedSz:]
+		buf = buf[copiedSz:]
+		sz += copiedSz
+	}
+	return
+}
+
+// Close stops workers and reports truncation, if final chunk has not been read.
+func (psd *parallelStreamDecryptor) Close() (err error) {
+	psd.stop()
+	if psd.ErrorCache == io.EOF || (psd.ErrorCache == nil && psd.FinalChunkRead && len(psd.DecBuffer) == 0) {
+		return nil
+	} else if psd.ErrorCache != nil {
+		return psd.ErrorCache
+	}
+	psd.ErrorCache = uciph.ErrStreamTruncated
+	return psd.ErrorCache
+}
+
+// NewParallelStreamEncryptor creates StreamEncryptor, which encrypts chunks on multiple goroutines
+// configured with ParallelOptions from options. Format is configured with StreamOptions from options.
+//
+// Output is same as output of NewDefaultStreamEncryptorWithOptions given same encryptor, as long as
+// encryptor is deterministic, like one using NonceModeCounter.
+// Encryptor has to be ChunkEncryptor. Workers are stopped once Write returns error.
+// Otherwise Close has to be called in order to stop them.
+func NewParallelStreamEncryptor(e Encryptor, w io.Writer, options interface{}) (se StreamEncryptor, err error) {
+	ce, ok := e.(ChunkEncryptor)
+	if !ok {
+		err = uciph.ErrParallelNotSupported
+		return
+	}
+
+	so := GetStreamOptions(options)
+	err = so.Validate()
+	if err != nil {
+		return
+	}
+	so = so.withDefaults()
+	po := GetParallelOptions(options).withDefaults()
+
+	pse := &parallelStreamEncryptor{
+		DstBufferSize:        so.ChunkSize,
+		ChunkCounterEncoding: so.ChunkCounterEncoding,
+		ChunkLengthEncoding:  so.lengthEncoding(),
+		MaxInFlightChunks:    po.MaxInFlightChunks,
+		Sink:                 w,
+	}
+	pse.EncBuffer = pse.newBuffer()
+	pse.Jobs = startParallelWorkers(po, func(job *parallelJob) {
+		job.Result, job.Err = ce.EncryptChunk(job.Index, job.In, job.In[:0])
+	})
+
+	se = pse
+	return
+}
+
+// NewParallelStreamDecryptor creates StreamDecryptor, which decrypts chunks on multiple goroutines
+// configured with ParallelOptions from options. Format is configured with StreamOptions from options.
+//
+// It decrypts streams created with either NewDefaultStreamEncryptorWithOptions or NewParallelStreamEncryptor.
+// Decryptor has to be ChunkDecryptor. Workers are stopped once final chunk is read or Read returns error.
+// Close has to be called in order to stop them if stream is not read until then.
+func NewParallelStreamDecryptor(d Decryptor, r io.Reader, options interface{}) (sd StreamDecryptor, err error) {
+	cd, ok := d.(ChunkDecryptor)
+	if !ok {
+		err = uciph.ErrParallelNotSupported
+		return
+	}
+
+	so := GetStreamOptions(options)
+	err = so.Validate()
+	if err != nil {
+		return
+	}
+	so = so.withDefaults()
+	po := GetParallelOptions(options).withDefaults()
+
+	sd = &parallelStreamDecryptor{
+		DstBufferSize:        so.ChunkSize,
+		ChunkCounterEncoding: so.ChunkCounterEncoding,
+		ChunkLengthEncoding:  so.lengthEncoding(),
+		MaxBufferSize:        uint64(so.MaxChunkSize),
+		Overhead:             cd.Overhead(),
+		MaxInFlightChunks:    po.MaxInFlightChunks,
+		Jobs: startParallelWorkers(po, func(job *parallelJob) {
+			job.Result, job.Err = cd.DecryptChunk(job.Index, job.In, job.In[:0])
+		}),
+		Source: r,
+	}
+	return
+}
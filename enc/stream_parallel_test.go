@@ -0,0 +1,243 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/enc"
+	"github.com/teawithsand/uciph/rand"
+)
+
+func TestParallelStreamED(t *testing.T) {
+	for name, so := range map[string]enc.StreamOptions{
+		"Default":        {},
+		"SmallChunks":    {ChunkSize: 1000, ChunkCounterEncoding: enc.Byte4},
+		"NoChunkLengths": {ChunkSize: 1000, NoChunkLengths: true},
+	} {
+		opts := copts.Options{}.
+			WithNonceMode(enc.NonceModeCounter).
+			WithStreamOptions(so).
+			WithParallelOptions(enc.ParallelOptions{Workers: 4})
+		t.Run(name, func(t *testing.T) {
+			ek, dk := makeChaCha20Keys(t)
+			ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+				e, err := ek(opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				se, err := enc.NewParallelStreamEncryptor(e, w, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return se
+			}, func(r io.Reader) enc.StreamDecryptor {
+				d, err := dk(opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sd, err := enc.NewParallelStreamDecryptor(d, r, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return sd
+			})
+		})
+	}
+}
+
+func TestParallelStreamMatchesSequential(t *testing.T) {
+	data := make([]byte, 100*1000+123)
+	_, err := io.ReadFull(rand.DefaultRNG(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ek, dk := makeChaCha20Keys(t)
+	opts := copts.Options{}.
+		WithNonceMode(enc.NonceModeCounter).
+		WithStreamOptions(enc.StreamOptions{ChunkSize: 1000}).
+		WithParallelOptions(enc.ParallelOptions{Workers: 8, MaxInFlightChunks: 5})
+
+	encrypt := func(parallel bool) []byte {
+		e, err := ek(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := bytes.NewBuffer(nil)
+		var se enc.StreamEncryptor
+		if parallel {
+			se, err = enc.NewParallelStreamEncryptor(e, b, opts)
+		} else {
+			se, err = enc.NewDefaultStreamEncryptorWithOptions(e, b, opts)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// odd sized writes, so chunks are filled by multiple writes
+		for i := 0; i < len(data); i += 777 {
+			end := i + 777
+			if end > len(data) {
+				end = len(data)
+			}
+			_, err = se.Write(data[i:end])
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = se.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+
+	sequential := encrypt(false)
+	parallel := encrypt(true)
+	if !bytes.Equal(sequential, parallel) {
+		t.Fatal("Parallel encryptor output differs from sequential one")
+	}
+
+	d, err := dk(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := enc.NewParallelStreamDecryptor(d, bytes.NewReader(sequential), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ioutil.ReadAll(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sd.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, data) {
+		t.Fatal("Decrypted data differs")
+	}
+}
+
+func TestParallelStreamDetectsTampering(t *testing.T) {
+	const chunkSize = 1000
+	opts := copts.Options{}.
+		WithStreamOptions(enc.StreamOptions{ChunkSize: chunkSize, ChunkLengthEncoding: enc.Byte4}).
+		WithParallelOptions(enc.ParallelOptions{Workers: 4})
+
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewParallelStreamEncryptor(enc.BlankEncryptor(), b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(make([]byte, chunkSize*10+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := b.Bytes()
+
+	// length + counter + flag + data
+	frameSize := 4 + 1 + 1 + chunkSize
+
+	decrypt := func(data []byte) (err error) {
+		sd, err := enc.NewParallelStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(data), opts)
+		if err != nil {
+			return
+		}
+		defer sd.Close()
+		_, err = ioutil.ReadAll(sd)
+		return
+	}
+
+	t.Run("TruncatedAtChunkBoundary", func(t *testing.T) {
+		truncated := append(append([]byte{}, encrypted[:frameSize*3]...), 0, 0, 0, 0)
+		err := decrypt(truncated)
+		if !errors.Is(err, uciph.ErrStreamTruncated) {
+			t.Fatalf("Expected ErrStreamTruncated, got %v", err)
+		}
+	})
+
+	t.Run("Reordered", func(t *testing.T) {
+		reordered := append([]byte{}, encrypted...)
+		copy(reordered[frameSize*2:frameSize*3], encrypted[frameSize*3:frameSize*4])
+		copy(reordered[frameSize*3:frameSize*4], encrypted[frameSize*2:frameSize*3])
+		err := decrypt(reordered)
+		if !errors.Is(err, uciph.ErrStreamChunksReordered) {
+			t.Fatalf("Expected ErrStreamChunksReordered, got %v", err)
+		}
+	})
+
+	t.Run("TrailingData", func(t *testing.T) {
+		err := decrypt(append(append([]byte{}, encrypted...), 1))
+		if !errors.Is(err, uciph.ErrStreamLogicEnd) {
+			t.Fatalf("Expected ErrStreamLogicEnd, got %v", err)
+		}
+	})
+}
+
+// waitForGoroutines waits until there are at most n goroutines running.
+func waitForGoroutines(t *testing.T, n int) {
+	for i := 0; i < 100 && runtime.NumGoroutine() > n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runtime.NumGoroutine() > n {
+		t.Fatalf("Expected workers to be stopped, got %d goroutines instead of %d", runtime.NumGoroutine(), n)
+	}
+}
+
+func TestParallelStreamDecryptorStopsWorkersWithoutClose(t *testing.T) {
+	data := make([]byte, 10*1000+123)
+	ek, dk := makeChaCha20Keys(t)
+	opts := copts.Options{}.
+		WithNonceMode(enc.NonceModeCounter).
+		WithStreamOptions(enc.StreamOptions{ChunkSize: 1000}).
+		WithParallelOptions(enc.ParallelOptions{Workers: 16})
+
+	before := runtime.NumGoroutine()
+
+	e, err := ek(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewParallelStreamEncryptor(e, b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForGoroutines(t, before)
+
+	for _, stream := range [][]byte{b.Bytes(), b.Bytes()[:b.Len()/2]} {
+		d, err := dk(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd, err := enc.NewParallelStreamDecryptor(d, bytes.NewReader(stream), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// read exactly whole data or until error, but never close
+		_, _ = io.ReadFull(sd, make([]byte, len(data)))
+		waitForGoroutines(t, before)
+	}
+}
@@ -0,0 +1,179 @@
+package enc
+
+import (
+	"io"
+
+	"github.com/teawithsand/uciph"
+)
+
+// CorruptedChunk describes chunk, which could not be recovered.
+type CorruptedChunk struct {
+	Index uint64
+
+	// Offset and Size describe range of encrypted stream, which contains chunk.
+	Offset int64
+	Size   int64
+
+	// DataOffset and DataSize describe range of recovered data, which is filled with zeros instead of chunk data.
+	DataOffset int64
+	DataSize   int64
+
+	Err error
+}
+
+// RecoveryReport describes result of stream recovery.
+type RecoveryReport struct {
+	ChunkCount uint64 // count of chunks found in stream including corrupted ones
+	Corrupted  []CorruptedChunk
+
+	// Truncated is true if valid final chunk was not found, so some data at the end of stream may be missing.
+	// It's also true when final chunk is damaged, since then it's not known if it was final one indeed.
+	Truncated bool
+}
+
+// finalDataSize returns size of data of final chunk with given index, which takes frameSize bytes.
+// It returns -1 if there is no such size.
+func (l *streamLayout) finalDataSize(index uint64, frameSize uint64) int {
+	emptySize := l.encChunkSize(index, 0)
+	if emptySize < 0 {
+		return -1
+	}
+
+	var encSize uint64
+	if l.ChunkLengthEncoding.IsValid() {
+		// size of length depends on length itself, so all possible ones are checked
+		found := false
+		for lsz := 1; lsz <= maxIntEncodingSize && uint64(lsz) <= frameSize; lsz++ {
+			if l.ChunkLengthEncoding.Size(frameSize-uint64(lsz)) == lsz {
+				encSize = frameSize - uint64(lsz)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return -1
+		}
+	} else {
+		encSize = frameSize
+	}
+
+	if encSize < uint64(emptySize) || encSize-uint64(emptySize) >= uint64(l.ChunkSize) {
+		return -1
+	}
+	return int(encSize - uint64(emptySize))
+}
+
+// RecoverStream decrypts as much as possible of damaged stream of given size created with default stream encryptor
+// and writes it to w. It has to be given same StreamOptions in options as encryptor was.
+//
+// Unlike strict decryptors it does not stop at first chunk, which fails authentication.
+// All chunks except final one have same size, so position of each chunk is computed from its index
+// and damaged chunk does not affect any other. Damaged chunks are replaced with zeros, so recovered data
+// keeps its offsets, and they are listed in report.
+//
+// Chunks, which can't be read, for instance because of bad sector, are reported as corrupted as well.
+//
+// Recovered data is not authenticated as a whole. Report has to be checked before it's used.
+// Error is returned only if writing data fails.
+func RecoverStream(
+	d Decryptor,
+	r io.ReaderAt,
+	size int64,
+	w io.Writer,
+	options interface{},
+) (report RecoveryReport, err error) {
+	cd, ok := d.(ChunkDecryptor)
+	if !ok {
+		err = uciph.ErrRandomAccessNotSupported
+		return
+	}
+
+	so := GetStreamOptions(options)
+	err = so.Validate()
+	if err != nil {
+		return
+	}
+	so = so.withDefaults()
+
+	ssd := &seekableStreamDecryptor{
+		Layout: streamLayout{
+			ChunkSize:            so.ChunkSize,
+			Overhead:             cd.Overhead(),
+			ChunkCounterEncoding: so.ChunkCounterEncoding,
+			ChunkLengthEncoding:  so.lengthEncoding(),
+		},
+		Decryptor: cd,
+		Source:    r,
+	}
+	l := &ssd.Layout
+
+	// Terminator is skipped, if there is one. Only final chunk marks end of stream.
+	bodySize := uint64(0)
+	if size > 0 {
+		bodySize = uint64(size)
+	}
+	if l.ChunkLengthEncoding.IsValid() {
+		var terminator, readTerminator [maxIntEncodingSize]byte
+		terminatorSize := uint64(l.ChunkLengthEncoding.Encode(terminator[:], 0))
+		if bodySize >= terminatorSize {
+			// unreadable terminator is treated as missing one
+			_, rerr := r.ReadAt(readTerminator[:terminatorSize], int64(bodySize-terminatorSize))
+			if (rerr == nil || rerr == io.EOF) && readTerminator == terminator {
+				bodySize -= terminatorSize
+			}
+		}
+	}
+
+	fullChunks := l.fullChunks(bodySize)
+	lastOffset, _ := l.chunkOffset(fullChunks)
+	lastDataSize := -1
+	if bodySize > lastOffset {
+		lastDataSize = l.finalDataSize(fullChunks, bodySize-lastOffset)
+	}
+
+	ssd.ChunkCount = fullChunks + 1
+	ssd.LastDataSize = lastDataSize
+	report.ChunkCount = fullChunks
+	if lastDataSize >= 0 {
+		report.ChunkCount++
+	} else {
+		report.Truncated = true
+	}
+
+	zeros := make([]byte, so.ChunkSize)
+	for i := uint64(0); i < report.ChunkCount; i++ {
+		dataSize := so.ChunkSize
+		if i == fullChunks {
+			dataSize = lastDataSize
+		}
+
+		var data []byte
+		frame, chunkErr := ssd.readFrame(i)
+		if chunkErr == nil {
+			data, chunkErr = ssd.openFrame(i, frame)
+		}
+		if chunkErr != nil {
+			if i == fullChunks {
+				// it's not known whether damaged chunk was final one
+				report.Truncated = true
+			}
+
+			offset, _ := l.chunkOffset(i)
+			report.Corrupted = append(report.Corrupted, CorruptedChunk{
+				Index:      i,
+				Offset:     int64(offset),
+				Size:       int64(l.frameSize(i, dataSize)),
+				DataOffset: int64(i) * int64(so.ChunkSize),
+				DataSize:   int64(dataSize),
+				Err:        chunkErr,
+			})
+			data = zeros[:dataSize]
+		}
+
+		_, err = w.Write(data)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
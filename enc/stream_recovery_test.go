@@ -0,0 +1,174 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/enc"
+	"github.com/teawithsand/uciph/rand"
+)
+
+var errBadSector = errors.New("bad sector")
+
+// badSectorReader fails all reads, which touch given range.
+type badSectorReader struct {
+	R        io.ReaderAt
+	From, To int64
+}
+
+func (r *badSectorReader) ReadAt(buf []byte, off int64) (int, error) {
+	if off < r.To && off+int64(len(buf)) > r.From {
+		return 0, errBadSector
+	}
+	return r.R.ReadAt(buf, off)
+}
+
+func TestRecoverStream(t *testing.T) {
+	const chunkSize = 1000
+	for name, so := range map[string]enc.StreamOptions{
+		"Default":        {ChunkSize: chunkSize},
+		"NoChunkLengths": {ChunkSize: chunkSize, NoChunkLengths: true},
+	} {
+		so := so
+		t.Run(name, func(t *testing.T) {
+			opts := copts.Options{}.WithNonceMode(enc.NonceModeCounter).WithStreamOptions(so)
+			data := make([]byte, chunkSize*10+123)
+			_, err := io.ReadFull(rand.DefaultRNG(), data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e, d := makeChaCha20ED(t, enc.NonceModeCounter)
+			encrypt := func(data []byte) []byte {
+				b := bytes.NewBuffer(nil)
+				se, err := enc.NewDefaultStreamEncryptorWithOptions(e, b, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = se.Write(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = se.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return b.Bytes()
+			}
+			encrypted := encrypt(data)
+			// size of full chunk with its length
+			frameSize := len(encrypt(make([]byte, chunkSize))) - len(encrypt(nil))
+
+			recover := func(encrypted []byte) (res []byte, report enc.RecoveryReport) {
+				out := bytes.NewBuffer(nil)
+				report, err := enc.RecoverStream(d, bytes.NewReader(encrypted), int64(len(encrypted)), out, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return out.Bytes(), report
+			}
+
+			t.Run("Intact", func(t *testing.T) {
+				res, report := recover(encrypted)
+				if report.ChunkCount != 11 || len(report.Corrupted) != 0 || report.Truncated {
+					t.Fatalf("Invalid report: %+v", report)
+				}
+				if !bytes.Equal(res, data) {
+					t.Fatal("Recovered data differs")
+				}
+			})
+
+			t.Run("Corrupted", func(t *testing.T) {
+				damaged := append([]byte{}, encrypted...)
+				positions := []int{frameSize*2 + frameSize/2, frameSize*7 + frameSize/2, len(damaged) - 20}
+				for _, p := range positions {
+					damaged[p] ^= 1
+				}
+
+				res, report := recover(damaged)
+				// final chunk is damaged, so it's not known if stream is complete
+				if report.ChunkCount != 11 || len(report.Corrupted) != 3 || !report.Truncated {
+					t.Fatalf("Invalid report: %+v", report)
+				}
+				for i, index := range []uint64{2, 7, 10} {
+					cc := report.Corrupted[i]
+					if cc.Index != index || cc.DataOffset != int64(index)*chunkSize {
+						t.Fatalf("Invalid corrupted chunk: %+v", cc)
+					}
+					if cc.Offset > int64(positions[i]) || cc.Offset+cc.Size <= int64(positions[i]) {
+						t.Fatalf("Corrupted chunk range does not contain damaged byte: %+v", cc)
+					}
+				}
+				if report.Corrupted[2].DataSize != 123 {
+					t.Fatalf("Invalid size of final chunk: %+v", report.Corrupted[2])
+				}
+
+				if len(res) != len(data) {
+					t.Fatal("Recovered data has invalid size")
+				}
+				for i := 0; i < len(data); i += chunkSize {
+					end := i + chunkSize
+					if end > len(data) {
+						end = len(data)
+					}
+					index := i / chunkSize
+					if index == 2 || index == 7 || index == 10 {
+						if !bytes.Equal(res[i:end], make([]byte, end-i)) {
+							t.Fatalf("Corrupted chunk %d is not zeroed", index)
+						}
+					} else if !bytes.Equal(res[i:end], data[i:end]) {
+						t.Fatalf("Chunk %d was not recovered", index)
+					}
+				}
+			})
+
+			t.Run("Unreadable", func(t *testing.T) {
+				from := int64(frameSize*4 + frameSize/2)
+				r := &badSectorReader{R: bytes.NewReader(encrypted), From: from, To: from + 10}
+				out := bytes.NewBuffer(nil)
+				report, err := enc.RecoverStream(d, r, int64(len(encrypted)), out, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.ChunkCount != 11 || len(report.Corrupted) != 1 || report.Truncated {
+					t.Fatalf("Invalid report: %+v", report)
+				}
+				cc := report.Corrupted[0]
+				if cc.Index != 4 || cc.Err != errBadSector || cc.Offset+cc.Size <= from {
+					t.Fatalf("Invalid corrupted chunk: %+v", cc)
+				}
+
+				res := out.Bytes()
+				expected := append([]byte{}, data...)
+				copy(expected[chunkSize*4:chunkSize*5], make([]byte, chunkSize))
+				if !bytes.Equal(res, expected) {
+					t.Fatal("Recovered data differs")
+				}
+			})
+
+			t.Run("Truncated", func(t *testing.T) {
+				res, report := recover(encrypted[:frameSize*4])
+				if report.ChunkCount != 4 || len(report.Corrupted) != 0 || !report.Truncated {
+					t.Fatalf("Invalid report: %+v", report)
+				}
+				if !bytes.Equal(res, data[:chunkSize*4]) {
+					t.Fatal("Recovered data differs")
+				}
+			})
+		})
+	}
+}
+
+func TestRecoverStreamRequiresChunkDecryptor(t *testing.T) {
+	_, err := enc.RecoverStream(enc.DecryptorFunc(func(in, appendTo []byte) ([]byte, error) {
+		return append(appendTo, in...), nil
+	}), bytes.NewReader(nil), 0, ioutil.Discard, nil)
+	if err != uciph.ErrRandomAccessNotSupported {
+		t.Fatalf("Expected ErrRandomAccessNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package enc
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/teawithsand/uciph"
+)
+
+const streamEncryptorStateVersion = 1
+
+// streamEncryptorStateSize is size of encoded StreamEncryptorState without key ID.
+const streamEncryptorStateSize = 1 + 1 + 1 + 1 + 4 + 8 + 8
+
+// StreamEncryptorState is state of default stream encryptor, which may be stored,
+// so encryption of stream can be resumed later, for instance after restart of process.
+//
+// It never contains key nor any data. KeyID may be set by user to remember which key was used.
+type StreamEncryptorState struct {
+	KeyID []byte
+
+	StreamOptions StreamOptions
+
+	// ChunkCounter is index of next chunk. In NonceModeCounter it's also value of NonceCounter used
+	// to encrypt next chunk, since nonce of each chunk is derived from its index.
+	ChunkCounter uint64
+
+	// Offset is count of bytes written by encryptor.
+	// Data written after it has to be discarded, before encryption is resumed.
+	// Header written before stream, if any, is not counted.
+	Offset uint64
+}
+
+// MarshalBinary encodes StreamEncryptorState.
+//
+// Format is:
+// version(1 byte), flags(1 byte), chunk length encoding(1 byte), chunk counter encoding(1 byte),
+// chunk size(4 byte big endian), chunk counter(8 byte big endian), offset(8 byte big endian) and key ID.
+func (s *StreamEncryptorState) MarshalBinary() (data []byte, err error) {
+	so := s.StreamOptions.withDefaults()
+	err = so.Validate()
+	if err != nil {
+		return
+	}
+	if uint64(so.ChunkSize) > uint64(^uint32(0)) {
+		err = uciph.ErrStreamOptionsInvalid
+		return
+	}
+
+	var flags byte
+	if so.NoChunkLengths {
+		flags |= streamHeaderFlagNoChunkLengths
+	}
+
+	data = make([]byte, streamEncryptorStateSize, streamEncryptorStateSize+len(s.KeyID))
+	data[0] = streamEncryptorStateVersion
+	data[1] = flags
+	data[2] = byte(so.ChunkLengthEncoding)
+	data[3] = byte(so.ChunkCounterEncoding)
+	binary.BigEndian.PutUint32(data[4:], uint32(so.ChunkSize))
+	binary.BigEndian.PutUint64(data[8:], s.ChunkCounter)
+	binary.BigEndian.PutUint64(data[16:], s.Offset)
+	data = append(data, s.KeyID...)
+	return
+}
+
+// UnmarshalBinary decodes StreamEncryptorState encoded with MarshalBinary.
+func (s *StreamEncryptorState) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < streamEncryptorStateSize || data[0] != streamEncryptorStateVersion {
+		return uciph.ErrStreamOptionsInvalid
+	}
+	if data[1]&^streamHeaderFlagNoChunkLengths != 0 {
+		return uciph.ErrStreamOptionsInvalid
+	}
+
+	so := StreamOptions{
+		ChunkSize:            int(binary.BigEndian.Uint32(data[4:])),
+		ChunkLengthEncoding:  IntEncoding(data[2]),
+		ChunkCounterEncoding: IntEncoding(data[3]),
+		NoChunkLengths:       data[1]&streamHeaderFlagNoChunkLengths != 0,
+	}
+	if so.ChunkSize <= 0 || so.Validate() != nil {
+		return uciph.ErrStreamOptionsInvalid
+	}
+
+	var keyID []byte
+	if len(data) > streamEncryptorStateSize {
+		keyID = append(keyID, data[streamEncryptorStateSize:]...)
+	}
+
+	*s = StreamEncryptorState{
+		KeyID:         keyID,
+		StreamOptions: so,
+		ChunkCounter:  binary.BigEndian.Uint64(data[8:]),
+		Offset:        binary.BigEndian.Uint64(data[16:]),
+	}
+	return
+}
+
+// ExportState flushes encryptor and returns its state, so encryption may be resumed with ResumeStreamEncryptor.
+// Encryptor can't be used after that, not even closed, since any chunk it would write would use
+// nonce, which belongs to resumed encryptor.
+//
+// If there is buffered data, it's written as flushed chunk, so chunk lengths are required then.
+//
+// State is usable only for streams created with NewDefaultStreamEncryptor or NewDefaultStreamEncryptorWithOptions.
+// Streams with header, like subkey stream, export state as well, but it doesn't contain header nor key derived from it,
+// so they can't be resumed.
+func (dse *defaultStreamEncryptor) ExportState() (state StreamEncryptorState, err error) {
+	if dse.ErrorCache != nil {
+		err = dse.ErrorCache
+		return
+	}
+	if dse.CurrentEncBufferSize > 0 {
+		err = dse.Flush()
+		if err != nil {
+			return
+		}
+	}
+	dse.ErrorCache = errStreamEncryptorExported
+
+	state = StreamEncryptorState{
+		StreamOptions: StreamOptions{
+			ChunkSize:            dse.DstBufferSize,
+			ChunkCounterEncoding: dse.ChunkCounterEncoding,
+			ChunkLengthEncoding:  dse.ChunkLengthEncoding,
+			NoChunkLengths:       !dse.ChunkLengthEncoding.IsValid(),
+		},
+		ChunkCounter: dse.ChunkCounter,
+		Offset:       dse.Written,
+	}
+	if state.StreamOptions.NoChunkLengths {
+		state.StreamOptions.ChunkLengthEncoding = ByteVar
+	}
+	return
+}
+
+// resumedEncryptor encrypts chunks starting from given index.
+type resumedEncryptor struct {
+	Encryptor ChunkEncryptor
+	Index     uint64
+}
+
+func (e *resumedEncryptor) Encrypt(in, appendTo []byte) (res []byte, err error) {
+	res, err = e.Encryptor.EncryptChunk(e.Index, in, appendTo)
+	if err != nil {
+		return
+	}
+	e.Index++
+	return
+}
+
+// ResumeStreamEncryptor creates default stream encryptor, which continues stream, which state was exported
+// with ExportState. Writer has to be positioned at state's Offset, so output has to be truncated to it,
+// if anything was written after state was exported.
+//
+// Each state may be resumed only once and only latest state of stream may be resumed.
+// Chunks are encrypted starting from state's ChunkCounter, so resuming older state or resuming same state twice
+// encrypts different data with nonces, which were already used. Once encryptor is resumed,
+// its state should be exported again and stored state should be replaced with it.
+//
+// Encryptor has to be created with same key and options as original one was. It has to be ChunkEncryptor.
+// Only streams created with NewDefaultStreamEncryptor or NewDefaultStreamEncryptorWithOptions can be resumed.
+func ResumeStreamEncryptor(e Encryptor, w io.Writer, state StreamEncryptorState) (se ResumableStreamEncryptor, err error) {
+	ce, ok := e.(ChunkEncryptor)
+	if !ok {
+		err = uciph.ErrResumeNotSupported
+		return
+	}
+
+	res, err := NewDefaultStreamEncryptorWithOptions(&resumedEncryptor{
+		Encryptor: ce,
+		Index:     state.ChunkCounter,
+	}, w, state.StreamOptions)
+	if err != nil {
+		return
+	}
+
+	dse := res.(*defaultStreamEncryptor)
+	dse.ChunkCounter = state.ChunkCounter
+	dse.Written = state.Offset
+	se = dse
+	return
+}
@@ -0,0 +1,132 @@
+package enc_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/enc"
+)
+
+func TestResumeStreamEncryptor(t *testing.T) {
+	for name, so := range map[string]enc.StreamOptions{
+		"Default":        {ChunkSize: 100},
+		"NoChunkLengths": {ChunkSize: 100, NoChunkLengths: true},
+	} {
+		so := so
+		t.Run(name, func(t *testing.T) {
+			opts := copts.Options{}.WithNonceMode(enc.NonceModeCounter).WithStreamOptions(so)
+			ek, dk := makeChaCha20Keys(t)
+
+			e, err := ek(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := bytes.NewBuffer(nil)
+			se, err := enc.NewDefaultStreamEncryptorWithOptions(e, b, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			first := bytes.Repeat([]byte{1}, 250)
+			if so.NoChunkLengths {
+				// partial chunk can't be flushed without lengths
+				first = first[:200]
+			}
+			_, err = se.Write(first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, err := se.(enc.ResumableStreamEncryptor).ExportState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			state.KeyID = []byte("upload key")
+			if state.Offset != uint64(b.Len()) {
+				t.Fatalf("Invalid offset: %d, written %d", state.Offset, b.Len())
+			}
+
+			// encryptor can't be used after its state has been exported
+			_, err = se.Write(bytes.Repeat([]byte{2}, 300))
+			if err == nil {
+				t.Fatal("Expected error from write after export")
+			}
+			if se.Close() == nil {
+				t.Fatal("Expected error from close after export")
+			}
+
+			// upload is interrupted after some garbage is written
+			b.Write(bytes.Repeat([]byte{2}, 300))
+
+			rawState, err := state.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var readState enc.StreamEncryptorState
+			err = readState.UnmarshalBinary(rawState)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(readState.KeyID) != "upload key" || readState.ChunkCounter != state.ChunkCounter || readState.Offset != state.Offset {
+				t.Fatalf("Invalid state: %+v", readState)
+			}
+
+			// data written after state was exported is discarded and encryption is resumed with new encryptor
+			b.Truncate(int(readState.Offset))
+			e, err = ek(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rse, err := enc.ResumeStreamEncryptor(e, b, readState)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second := bytes.Repeat([]byte{3}, 321)
+			_, err = rse.Write(second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = rse.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d, err := dk(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sd, err := enc.NewDefaultStreamDecryptorWithOptions(d, b, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := ioutil.ReadAll(sd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = sd.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(res, append(append([]byte{}, first...), second...)) {
+				t.Fatal("Decrypted data differs")
+			}
+		})
+	}
+}
+
+func TestResumeStreamEncryptorRequiresChunkEncryptor(t *testing.T) {
+	key, err := enc.ChaCha20Poly1305Keygen(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := enc.NewRekeyingEncryptor(key, enc.CipherChaCha20Poly1305, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = enc.ResumeStreamEncryptor(e, ioutil.Discard, enc.StreamEncryptorState{ChunkCounter: 10})
+	if err != uciph.ErrResumeNotSupported {
+		t.Fatalf("Expected ErrResumeNotSupported, got %v", err)
+	}
+}
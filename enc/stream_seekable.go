@@ -0,0 +1,399 @@
+package enc
+
+import (
+	"errors"
+	"io"
+
+	"github.com/teawithsand/uciph"
+)
+
+var errNegativePosition = errors.New("uciph/enc: Negative position in stream")
+
+// streamLayout computes positions of chunks in stream created by default stream encryptor.
+// All chunks except last one, which is final, have to contain ChunkSize bytes of data.
+type streamLayout struct {
+	ChunkSize int
+	Overhead  int
+
+	ChunkCounterEncoding IntEncoding
+	ChunkLengthEncoding  IntEncoding
+}
+
+func (l *streamLayout) counterSize(index uint64) int {
+	if !l.ChunkCounterEncoding.IsValid() {
+		return 0
+	}
+	return l.ChunkCounterEncoding.Size(index)
+}
+
+// encChunkSize returns size of encrypted chunk with given index, which contains dataSize bytes of data.
+// It returns -1 if chunk with such index can't exist.
+func (l *streamLayout) encChunkSize(index uint64, dataSize int) int {
+	csz := l.counterSize(index)
+	if csz < 0 {
+		return -1
+	}
+	return csz + 1 + dataSize + l.Overhead
+}
+
+// frameSize returns size of encrypted chunk with given index including its length.
+// It returns -1 if chunk with such index can't exist.
+func (l *streamLayout) frameSize(index uint64, dataSize int) int {
+	esz := l.encChunkSize(index, dataSize)
+	if esz < 0 {
+		return -1
+	}
+	if !l.ChunkLengthEncoding.IsValid() {
+		return esz
+	}
+	lsz := l.ChunkLengthEncoding.Size(uint64(esz))
+	if lsz < 0 {
+		return -1
+	}
+	return lsz + esz
+}
+
+// chunkOffset returns offset of chunk with given index in stream.
+// It assumes that all chunks before it are full.
+// It returns false if chunk with such index can't exist.
+func (l *streamLayout) chunkOffset(index uint64) (offset uint64, ok bool) {
+	i := uint64(0)
+	for i < index {
+		// Chunks with counters of same encoded size have same frame size,
+		// so there is no need to iterate over all of them.
+		end := index
+		if l.ChunkCounterEncoding == ByteVar {
+			shift := 7 * uint(l.counterSize(i))
+			if shift < 64 && uint64(1)<<shift < end {
+				end = uint64(1) << shift
+			}
+		}
+
+		fsz := l.frameSize(i, l.ChunkSize)
+		if fsz < 0 {
+			return
+		}
+		offset += (end - i) * uint64(fsz)
+		i = end
+	}
+	ok = true
+	return
+}
+
+// fullChunks returns count of full chunks, which fit in given size.
+func (l *streamLayout) fullChunks(size uint64) uint64 {
+	// Offset of chunk grows with its index, so binary search may be used.
+	lo := uint64(0)
+	hi := size/uint64(l.frameSize(0, l.ChunkSize)) + 1
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		offset, ok := l.chunkOffset(mid)
+		if ok && offset <= size {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+type seekableStreamDecryptor struct {
+	Layout    streamLayout
+	Decryptor ChunkDecryptor
+	Source    io.ReaderAt
+
+	ChunkCount   uint64 // count of chunks including final one
+	LastDataSize int    // size of final chunk's data, which is always less than ChunkSize
+	DataSize     int64
+
+	Position int64
+
+	CachedChunkIndex uint64
+	CachedChunk      []byte
+}
+
+// locateEnd finds out how many chunks stream of given size contains.
+// It makes sure that stream ends with final chunk.
+func (ssd *seekableStreamDecryptor) locateEnd(size int64) (err error) {
+	l := &ssd.Layout
+
+	// 1. Check if stream ends with terminator, if it has one
+	var terminator [maxIntEncodingSize]byte
+	terminatorSize := 0
+	if l.ChunkLengthEncoding.IsValid() {
+		terminatorSize = l.ChunkLengthEncoding.Encode(terminator[:], 0)
+	}
+	if size < int64(terminatorSize) {
+		return uciph.ErrStreamTruncated
+	}
+	bodySize := uint64(size) - uint64(terminatorSize)
+
+	var readTerminator [maxIntEncodingSize]byte
+	_, err = ssd.Source.ReadAt(readTerminator[:terminatorSize], int64(bodySize))
+	if err == io.EOF {
+		err = nil
+	} else if err != nil {
+		return
+	}
+	if readTerminator != terminator {
+		return uciph.ErrStreamTruncated
+	}
+
+	// 2. Find count of full chunks, which fit in stream.
+	fullChunks := l.fullChunks(bodySize)
+	offset, _ := l.chunkOffset(fullChunks)
+	rem := bodySize - offset
+
+	// 3. Rest of data has to be final chunk, which is never full
+	if rem == 0 {
+		return uciph.ErrStreamTruncated
+	}
+	encSize := rem
+	if l.ChunkLengthEncoding.IsValid() {
+		var lengthBuffer [maxIntEncodingSize]byte
+		lengthBufferSize := maxIntEncodingSize
+		if rem < uint64(lengthBufferSize) {
+			lengthBufferSize = int(rem)
+		}
+		_, err = ssd.Source.ReadAt(lengthBuffer[:lengthBufferSize], int64(offset))
+		if err == io.EOF {
+			err = nil
+		} else if err != nil {
+			return
+		}
+
+		var lsz int
+		encSize, lsz = l.ChunkLengthEncoding.DecodeBytes(lengthBuffer[:lengthBufferSize])
+		if lsz < 0 || encSize != rem-uint64(lsz) {
+			return uciph.ErrStreamTruncated
+		}
+	}
+
+	lastDataSize := int64(encSize) - int64(l.encChunkSize(fullChunks, 0))
+	if lastDataSize < 0 || lastDataSize >= int64(l.ChunkSize) {
+		return uciph.ErrStreamTruncated
+	}
+
+	ssd.ChunkCount = fullChunks + 1
+	ssd.LastDataSize = int(lastDataSize)
+	ssd.DataSize = int64(fullChunks)*int64(l.ChunkSize) + lastDataSize
+
+	// 4. Make sure that it's final chunk indeed.
+	// Otherwise stream could have been cut at chunk boundary.
+	_, err = ssd.readChunk(fullChunks)
+	if errors.Is(err, uciph.ErrStreamParamsMismatch) {
+		err = uciph.ErrStreamTruncated
+	}
+	return
+}
+
+// chunkDataSize returns size of data of chunk with given index.
+func (ssd *seekableStreamDecryptor) chunkDataSize(index uint64) int {
+	if index == ssd.ChunkCount-1 {
+		return ssd.LastDataSize
+	}
+	return ssd.Layout.ChunkSize
+}
+
+// readChunk reads and decrypts chunk with given index.
+func (ssd *seekableStreamDecryptor) readChunk(index uint64) (data []byte, err error) {
+	frame, err := ssd.readFrame(index)
+	if err != nil {
+		return
+	}
+	return ssd.openFrame(index, frame)
+}
+
+// readFrame reads encrypted chunk with given index including its length.
+func (ssd *seekableStreamDecryptor) readFrame(index uint64) (frame []byte, err error) {
+	l := &ssd.Layout
+	offset, ok := l.chunkOffset(index)
+	if !ok {
+		return nil, uciph.ErrCiphertextInvalid
+	}
+
+	frame = make([]byte, l.frameSize(index, ssd.chunkDataSize(index)))
+	_, err = ssd.Source.ReadAt(frame, int64(offset))
+	if err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+// openFrame decrypts frame read with readFrame and checks if it's chunk with given index.
+func (ssd *seekableStreamDecryptor) openFrame(index uint64, frame []byte) (data []byte, err error) {
+	l := &ssd.Layout
+
+	final := index == ssd.ChunkCount-1
+	dataSize := ssd.chunkDataSize(index)
+	encSize := l.encChunkSize(index, dataSize)
+	frameSize := len(frame)
+
+	// 1. Check if length of frame is valid
+	if l.ChunkLengthEncoding.IsValid() {
+		readEncSize, lsz := l.ChunkLengthEncoding.DecodeBytes(frame)
+		if lsz != frameSize-encSize || readEncSize != uint64(encSize) {
+			return nil, uciph.ErrCiphertextInvalid
+		}
+		frame = frame[lsz:]
+	}
+
+	// 2. Decrypt it
+	data, err = ssd.Decryptor.DecryptChunk(index, frame, frame[:0])
+	if err != nil {
+		return
+	}
+
+	// 3. Check if it was not moved from other place in stream
+	if l.ChunkCounterEncoding.IsValid() {
+		chunkCounter, chunkCounterSize := l.ChunkCounterEncoding.DecodeBytes(data)
+		if chunkCounterSize < 0 {
+			return nil, uciph.ErrCiphertextInvalid
+		}
+		if chunkCounter != index {
+			return nil, uciph.ErrStreamChunksReordered
+		}
+		data = data[chunkCounterSize:]
+	}
+
+	// 4. Check if only last chunk is marked as final
+	if len(data) < 1 || data[0]&^streamChunkFlagFinal != 0 {
+		return nil, uciph.ErrCiphertextInvalid
+	}
+	if (data[0]&streamChunkFlagFinal != 0) != final {
+		return nil, uciph.ErrStreamParamsMismatch
+	}
+	data = data[1:]
+
+	if len(data) != dataSize {
+		return nil, uciph.ErrCiphertextInvalid
+	}
+	return
+}
+
+func (ssd *seekableStreamDecryptor) Size() int64 {
+	return ssd.DataSize
+}
+
+func (ssd *seekableStreamDecryptor) ReadAt(buf []byte, off int64) (sz int, err error) {
+	if off < 0 {
+		return 0, errNegativePosition
+	}
+
+	chunkSize := int64(ssd.Layout.ChunkSize)
+	for len(buf) > 0 && off < ssd.DataSize {
+		var data []byte
+		data, err = ssd.readChunk(uint64(off / chunkSize))
+		if err != nil {
+			return
+		}
+
+		copiedSz := copy(buf, data[off%chunkSize:])
+		buf = buf[copiedSz:]
+		off += int64(copiedSz)
+		sz += copiedSz
+	}
+
+	if len(buf) > 0 {
+		err = io.EOF
+	}
+	return
+}
+
+func (ssd *seekableStreamDecryptor) Read(buf []byte) (sz int, err error) {
+	if len(buf) == 0 {
+		return
+	}
+	if ssd.Position >= ssd.DataSize {
+		return 0, io.EOF
+	}
+
+	chunkSize := int64(ssd.Layout.ChunkSize)
+	index := uint64(ssd.Position / chunkSize)
+	if ssd.CachedChunk == nil || ssd.CachedChunkIndex != index {
+		ssd.CachedChunk = nil
+
+		var data []byte
+		data, err = ssd.readChunk(index)
+		if err != nil {
+			return
+		}
+		ssd.CachedChunk = data
+		ssd.CachedChunkIndex = index
+	}
+
+	sz = copy(buf, ssd.CachedChunk[ssd.Position%chunkSize:])
+	ssd.Position += int64(sz)
+	return
+}
+
+func (ssd *seekableStreamDecryptor) Seek(offset int64, whence int) (pos int64, err error) {
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = ssd.Position + offset
+	case io.SeekEnd:
+		pos = ssd.DataSize + offset
+	default:
+		return ssd.Position, errors.New("uciph/enc: Invalid whence")
+	}
+	if pos < 0 {
+		return ssd.Position, errNegativePosition
+	}
+
+	ssd.Position = pos
+	return
+}
+
+// Close releases cached chunk.
+// Stream end is checked when decryptor is created, so there is nothing else to check.
+func (ssd *seekableStreamDecryptor) Close() (err error) {
+	ssd.CachedChunk = nil
+	return
+}
+
+// NewSeekableStreamDecryptor creates SeekableStreamDecryptor, which decrypts stream of given size
+// created with default stream encryptor. It has to be given same StreamOptions in options as encryptor was.
+//
+// Only chunks, which contain requested data, are decrypted. Nonces of chunks are derived from their indexes,
+// so given decryptor has to be ChunkDecryptor.
+// Truncation is detected when decryptor is created, since final chunk is decrypted then. Reordering is detected when reordered chunk is read.
+func NewSeekableStreamDecryptor(
+	d Decryptor,
+	r io.ReaderAt,
+	size int64,
+	options interface{},
+) (sd SeekableStreamDecryptor, err error) {
+	cd, ok := d.(ChunkDecryptor)
+	if !ok {
+		err = uciph.ErrRandomAccessNotSupported
+		return
+	}
+
+	so := GetStreamOptions(options)
+	err = so.Validate()
+	if err != nil {
+		return
+	}
+	so = so.withDefaults()
+
+	ssd := &seekableStreamDecryptor{
+		Layout: streamLayout{
+			ChunkSize:            so.ChunkSize,
+			Overhead:             cd.Overhead(),
+			ChunkCounterEncoding: so.ChunkCounterEncoding,
+			ChunkLengthEncoding:  so.lengthEncoding(),
+		},
+		Decryptor: cd,
+		Source:    r,
+	}
+
+	err = ssd.locateEnd(size)
+	if err != nil {
+		return
+	}
+
+	sd = ssd
+	return
+}
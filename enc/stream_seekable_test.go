@@ -0,0 +1,213 @@
+package enc_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/enc"
+	"github.com/teawithsand/uciph/rand"
+)
+
+const testChunkSize = 1024 * 1024
+
+func encryptStream(e enc.Encryptor, data []byte) (res []byte, err error) {
+	b := bytes.NewBuffer(nil)
+	se := enc.NewDefaultStreamEncryptor(e, b)
+	_, err = se.Write(data)
+	if err != nil {
+		return
+	}
+	err = se.Close()
+	if err != nil {
+		return
+	}
+	res = b.Bytes()
+	return
+}
+
+func makeChaCha20ED(t *testing.T, nm enc.NonceMode) (enc.Encryptor, enc.Decryptor) {
+	opts := copts.Options{}.WithNonceMode(nm)
+	rawKey, err := enc.ChaCha20Poly1305Keygen(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := enc.ParseChaCha20Poly1305EncKey(rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dk, err := enc.ParseChaCha20Poly1305DecKey(rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ek(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := dk(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, d
+}
+
+func TestSeekableStreamDecryptor(t *testing.T) {
+	facs := map[string]func() (enc.Encryptor, enc.Decryptor){
+		"Blank": func() (enc.Encryptor, enc.Decryptor) {
+			return enc.BlankEncryptor(), enc.BlankDecryptor()
+		},
+		"ChaCha20_RandomNonce": func() (enc.Encryptor, enc.Decryptor) {
+			return makeChaCha20ED(t, enc.NonceModeRandom)
+		},
+		"ChaCha20_CounterNonce": func() (enc.Encryptor, enc.Decryptor) {
+			return makeChaCha20ED(t, enc.NonceModeCounter)
+		},
+	}
+
+	for name, fac := range facs {
+		fac := fac
+		t.Run(name, func(t *testing.T) {
+			for _, size := range []int{
+				0, 1, testChunkSize - 1, testChunkSize, testChunkSize + 1, testChunkSize*3 + 123,
+			} {
+				data := make([]byte, size)
+				_, err := io.ReadFull(rand.DefaultRNG(), data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				e, d := fac()
+				encrypted, err := encryptStream(e, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				sd, err := enc.NewSeekableStreamDecryptor(d, bytes.NewReader(encrypted), int64(len(encrypted)), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if sd.Size() != int64(size) {
+					t.Fatalf("Invalid size: got %d expected %d", sd.Size(), size)
+				}
+
+				// 1. Random access reads
+				for _, off := range []int{0, 1, size / 3, size / 2, size - 1, testChunkSize - 10, testChunkSize * 2} {
+					if off < 0 || off >= size {
+						continue
+					}
+					buf := make([]byte, 64)
+					sz, err := sd.ReadAt(buf, int64(off))
+					if err != nil && err != io.EOF {
+						t.Fatal(err)
+					}
+					if bytes.Compare(buf[:sz], data[off:off+sz]) != 0 {
+						t.Fatalf("Data at offset %d differs", off)
+					}
+				}
+
+				// 2. Sequential read after seek
+				off := int64(size / 2)
+				_, err = sd.Seek(off, io.SeekStart)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res, err := ioutil.ReadAll(sd)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if bytes.Compare(res, data[off:]) != 0 {
+					t.Fatal("Data read after seek differs")
+				}
+
+				err = sd.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSeekableStreamDecryptorDetectsTampering(t *testing.T) {
+	data := make([]byte, testChunkSize*2+1)
+	encrypted, err := encryptStream(enc.BlankEncryptor(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Truncated", func(t *testing.T) {
+		truncated := encrypted[:len(encrypted)-1]
+		_, err := enc.NewSeekableStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(truncated), int64(len(truncated)), nil)
+		if !errors.Is(err, uciph.ErrStreamTruncated) {
+			t.Fatalf("Expected ErrStreamTruncated, got %v", err)
+		}
+	})
+
+	t.Run("Reordered", func(t *testing.T) {
+		// With blank encryptor first two chunks have same size.
+		var lengthBuffer [binary.MaxVarintLen64]byte
+		frameSize := binary.PutUvarint(lengthBuffer[:], 1+1+testChunkSize) + 1 + 1 + testChunkSize
+
+		reordered := make([]byte, len(encrypted))
+		copy(reordered, encrypted)
+		copy(reordered[:frameSize], encrypted[frameSize:frameSize*2])
+		copy(reordered[frameSize:frameSize*2], encrypted[:frameSize])
+
+		sd, err := enc.NewSeekableStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(reordered), int64(len(reordered)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = sd.ReadAt(make([]byte, 1), 0)
+		if !errors.Is(err, uciph.ErrStreamChunksReordered) {
+			t.Fatalf("Expected ErrStreamChunksReordered, got %v", err)
+		}
+	})
+}
+
+func TestSeekableStreamDecryptorWithOptions(t *testing.T) {
+	for _, so := range []enc.StreamOptions{
+		{ChunkSize: 1000, ChunkCounterEncoding: enc.Byte4},
+		{ChunkSize: 1000, NoChunkLengths: true},
+	} {
+		opts := copts.Options{}.WithStreamOptions(so)
+		data := make([]byte, 100*1000+1)
+		_, err := io.ReadFull(rand.DefaultRNG(), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e, d := makeChaCha20ED(t, enc.NonceModeRandom)
+		b := bytes.NewBuffer(nil)
+		se, err := enc.NewDefaultStreamEncryptorWithOptions(e, b, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = se.Write(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = se.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sd, err := enc.NewSeekableStreamDecryptor(d, bytes.NewReader(b.Bytes()), int64(b.Len()), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 2000)
+		off := 50*1000 - 500
+		_, err = sd.ReadAt(buf, int64(off))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(buf, data[off:off+len(buf)]) != 0 {
+			t.Fatal("Data differs")
+		}
+	}
+}
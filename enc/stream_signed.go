@@ -0,0 +1,236 @@
+package enc
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/sig"
+)
+
+// Signed stream is plaintext followed by trailer: sign of plaintext and sign length(2 byte big endian).
+// Both of them are written to underlying StreamEncryptor, so trailer is encrypted as well.
+
+const signedStreamSignLengthSize = 2
+
+// maxSignedStreamTrailerSize is max size of trailer, which decryptor has to hold back
+// until it's known whether it's trailer or data.
+const maxSignedStreamTrailerSize = (1<<16 - 1) + signedStreamSignLengthSize
+
+type signingStreamEncryptor struct {
+	Sink   StreamEncryptor
+	Signer sig.Signer
+
+	ErrorCache error
+}
+
+func (sse *signingStreamEncryptor) Write(data []byte) (sz int, err error) {
+	if sse.ErrorCache != nil {
+		return 0, sse.ErrorCache
+	}
+	defer func() {
+		if err != nil {
+			sse.ErrorCache = err
+		}
+	}()
+
+	_, err = sse.Signer.Write(data)
+	if err != nil {
+		return
+	}
+	return sse.Sink.Write(data)
+}
+
+// Close signs all data written and writes trailer with sign.
+func (sse *signingStreamEncryptor) Close() (err error) {
+	if sse.ErrorCache != nil {
+		return sse.ErrorCache
+	}
+	defer func() {
+		if err != nil {
+			sse.ErrorCache = err
+		} else {
+			sse.ErrorCache = errStreamEncryptorClosed
+		}
+	}()
+
+	trailer, err := sse.Signer.Finalize(nil)
+	if err != nil {
+		return
+	}
+	if len(trailer) > maxSignedStreamTrailerSize-signedStreamSignLengthSize {
+		return uciph.ErrChunkTooBig
+	}
+	var length [signedStreamSignLengthSize]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(trailer)))
+	trailer = append(trailer, length[:]...)
+
+	_, err = sse.Sink.Write(trailer)
+	if err != nil {
+		return
+	}
+	return sse.Sink.Close()
+}
+
+type verifyingStreamDecryptor struct {
+	Source   StreamDecryptor
+	Verifier sig.Verifier
+
+	// Held is data, which may be part of trailer. It's never longer than maxSignedStreamTrailerSize
+	// after data is released.
+	Held      []byte
+	ReadSpace []byte
+	Ready     []byte // data, which has been passed to verifier, but was not returned yet
+
+	Verified   bool
+	ErrorCache error
+}
+
+// release passes data to verifier and makes it ready to be returned.
+func (vsd *verifyingStreamDecryptor) release(data []byte) (err error) {
+	_, err = vsd.Verifier.Write(data)
+	if err != nil {
+		return
+	}
+	vsd.Ready = append(vsd.Ready[:0], data...)
+	return
+}
+
+// finish splits held data into data and trailer and verifies sign.
+func (vsd *verifyingStreamDecryptor) finish() (err error) {
+	if len(vsd.Held) < signedStreamSignLengthSize {
+		return uciph.ErrSignInvalid
+	}
+	signSize := int(binary.BigEndian.Uint16(vsd.Held[len(vsd.Held)-signedStreamSignLengthSize:]))
+	dataSize := len(vsd.Held) - signedStreamSignLengthSize - signSize
+	if dataSize < 0 {
+		return uciph.ErrSignInvalid
+	}
+
+	data := vsd.Held[:dataSize]
+	_, err = vsd.Verifier.Write(data)
+	if err != nil {
+		return
+	}
+	if vsd.Verifier.Verify(vsd.Held[dataSize:dataSize+signSize]) != nil {
+		return uciph.ErrSignInvalid
+	}
+
+	vsd.Verified = true
+	vsd.Ready = append(vsd.Ready[:0], data...)
+	vsd.Held = vsd.Held[:0]
+	return
+}
+
+// load reads next part of stream and releases data, which can't be part of trailer anymore.
+func (vsd *verifyingStreamDecryptor) load() (err error) {
+	sz, err := vsd.Source.Read(vsd.ReadSpace)
+	vsd.Held = append(vsd.Held, vsd.ReadSpace[:sz]...)
+
+	if err == io.EOF {
+		return vsd.finish()
+	} else if err != nil {
+		return
+	}
+
+	if len(vsd.Held) > maxSignedStreamTrailerSize {
+		releasedSize := len(vsd.Held) - maxSignedStreamTrailerSize
+		err = vsd.release(vsd.Held[:releasedSize])
+		if err != nil {
+			return
+		}
+		vsd.Held = vsd.Held[:copy(vsd.Held, vsd.Held[releasedSize:])]
+	}
+	return
+}
+
+func (vsd *verifyingStreamDecryptor) Read(buf []byte) (sz int, err error) {
+	if vsd.ErrorCache != nil {
+		return 0, vsd.ErrorCache
+	}
+	defer func() {
+		if err != nil {
+			vsd.ErrorCache = err
+		}
+	}()
+
+	for len(buf) > 0 {
+		if len(vsd.Ready) > 0 {
+			copiedSz := copy(buf, vsd.Ready)
+			vsd.Ready = vsd.Ready[copiedSz:]
+			buf = buf[copiedSz:]
+			sz += copiedSz
+		} else if vsd.Verified {
+			if sz == 0 {
+				err = io.EOF
+			}
+			return
+		} else if sz > 0 {
+			// do not block, when some data may be returned
+			return
+		} else {
+			err = vsd.load()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Close returns uciph.ErrSignInvalid unless whole stream has been read and its sign is valid.
+func (vsd *verifyingStreamDecryptor) Close() (err error) {
+	err = vsd.Source.Close()
+	if vsd.ErrorCache != nil && vsd.ErrorCache != io.EOF {
+		return vsd.ErrorCache
+	}
+	if err != nil {
+		return
+	}
+	if !vsd.Verified {
+		return uciph.ErrSignInvalid
+	}
+	return
+}
+
+// NewSigningStreamEncryptor wraps StreamEncryptor, so all data written to it is signed with signer created from given key.
+// Sign is encrypted and written as stream trailer on close.
+//
+// Sign covers only plaintext, so decrypted stream proves who created it, but not who it was encrypted for.
+//
+// Signer may hold whole plaintext in memory until Close. Default Ed25519 and RSA signers do so,
+// unless options provide hasher with sig.SigningHasherOptions, in which case only its hash is kept.
+func NewSigningStreamEncryptor(se StreamEncryptor, sk sig.SigKey, options interface{}) (res StreamEncryptor, err error) {
+	signer, err := sk(options)
+	if err != nil {
+		return
+	}
+	res = &signingStreamEncryptor{
+		Sink:   se,
+		Signer: signer,
+	}
+	return
+}
+
+// NewVerifyingStreamDecryptor wraps StreamDecryptor, so it verifies stream created with NewSigningStreamEncryptor
+// with verifier created from given key.
+//
+// Data returned by Read is NOT verified. Sign is at the end of stream, so it's checked only once all data
+// has been returned. Last read returns uciph.ErrSignInvalid instead of io.EOF if sign is not valid.
+// Close returns uciph.ErrSignInvalid unless whole stream has been read and verified,
+// so data must not be trusted before Close returns nil.
+//
+// Verifier may hold whole plaintext in memory as signer does, see NewSigningStreamEncryptor.
+func NewVerifyingStreamDecryptor(sd StreamDecryptor, vk sig.VerKey, options interface{}) (res StreamDecryptor, err error) {
+	verifier, err := vk(options)
+	if err != nil {
+		return
+	}
+	res = &verifyingStreamDecryptor{
+		Source:    sd,
+		Verifier:  verifier,
+		Held:      make([]byte, 0, maxSignedStreamTrailerSize+32*1024),
+		ReadSpace: make([]byte, 32*1024),
+	}
+	return
+}
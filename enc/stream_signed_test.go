@@ -0,0 +1,164 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/enc"
+	"github.com/teawithsand/uciph/sig"
+)
+
+func makeEd25519Keys(t *testing.T) (sig.SigKey, sig.VerKey) {
+	gk := &sig.GeneratedKeys{}
+	err := sig.Ed25519Keygen(nil, gk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := sig.ParseEd25519SigKey(gk.SigningKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vk, err := sig.ParseEd25519VerKey(gk.VerifyingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sk, vk
+}
+
+func makeRSAKeys(t *testing.T) (sig.SigKey, sig.VerKey) {
+	gk := &sig.GeneratedKeys{}
+	err := sig.RSAKeygen(nil, sig.RSA2048, gk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := sig.ParseRSASigKey(gk.SigningKey, sig.RSA2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vk, err := sig.ParseRSAVerKey(gk.VerifyingKey, sig.RSA2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sk, vk
+}
+
+func TestSignedStreamED(t *testing.T) {
+	for name, makeKeys := range map[string]func(t *testing.T) (sig.SigKey, sig.VerKey){
+		"Ed25519": makeEd25519Keys,
+		"RSA":     makeRSAKeys,
+	} {
+		t.Run(name, func(t *testing.T) {
+			sk, vk := makeKeys(t)
+			ek, dk := makeChaCha20Keys(t)
+			ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+				e, err := ek(nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				se, err := enc.NewSigningStreamEncryptor(enc.NewDefaultStreamEncryptor(e, w), sk, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return se
+			}, func(r io.Reader) enc.StreamDecryptor {
+				d, err := dk(nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sd, err := enc.NewVerifyingStreamDecryptor(enc.NewDefaultStreamDecryptor(d, r), vk, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return sd
+			})
+		})
+	}
+}
+
+func signAndEncrypt(t *testing.T, sk sig.SigKey, data []byte) []byte {
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewSigningStreamEncryptor(enc.NewDefaultStreamEncryptor(enc.BlankEncryptor(), b), sk, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestSignedStreamDetectsOtherSender(t *testing.T) {
+	sk, _ := makeEd25519Keys(t)
+	_, otherVK := makeEd25519Keys(t)
+	encrypted := signAndEncrypt(t, sk, make([]byte, 100*1024))
+
+	sd, err := enc.NewVerifyingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(encrypted)), otherVK, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(sd)
+	if !errors.Is(err, uciph.ErrSignInvalid) {
+		t.Fatalf("Expected ErrSignInvalid from read, got %v", err)
+	}
+	err = sd.Close()
+	if !errors.Is(err, uciph.ErrSignInvalid) {
+		t.Fatalf("Expected ErrSignInvalid from close, got %v", err)
+	}
+}
+
+func TestSignedStreamCloseBeforeEnd(t *testing.T) {
+	sk, vk := makeEd25519Keys(t)
+	encrypted := signAndEncrypt(t, sk, make([]byte, 100*1024))
+
+	sd, err := enc.NewVerifyingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(encrypted)), vk, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadFull(sd, make([]byte, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sd.Close()
+	if err == nil {
+		t.Fatal("Expected error from close of partially read stream")
+	}
+}
+
+func TestSignedStreamDetectsUnsignedStream(t *testing.T) {
+	_, vk := makeEd25519Keys(t)
+	for _, data := range [][]byte{{}, {1}, {0, 0}, make([]byte, 100*1024)} {
+		b := bytes.NewBuffer(nil)
+		se := enc.NewDefaultStreamEncryptor(enc.BlankEncryptor(), b)
+		_, err := se.Write(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = se.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sd, err := enc.NewVerifyingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), b), vk, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ioutil.ReadAll(sd)
+		if !errors.Is(err, uciph.ErrSignInvalid) {
+			t.Fatalf("Expected ErrSignInvalid, got %v", err)
+		}
+		err = sd.Close()
+		if !errors.Is(err, uciph.ErrSignInvalid) {
+			t.Fatalf("Expected ErrSignInvalid from close, got %v", err)
+		}
+	}
+}
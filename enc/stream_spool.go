@@ -0,0 +1,276 @@
+package enc
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// defaultSpoolMemoryLimit is max amount of data kept in memory by spooling decryptor by default.
+const defaultSpoolMemoryLimit = 1024 * 1024
+
+// SpoolFile is temporary file used by spooling decryptor to store data, which does not fit in memory.
+// It's closed, when it's not needed anymore, so it should remove itself then.
+type SpoolFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+}
+
+// SpoolOptions configures where spooling decryptor stores data.
+type SpoolOptions struct {
+	// MemoryLimit is max amount of data kept in memory. If there is more data, it's moved to file.
+	// Zero means 1 MiB, negative value means that file is always used.
+	MemoryLimit int
+
+	// NewFile creates temporary file. By default file in os.TempDir is created, which is removed on close.
+	NewFile func() (SpoolFile, error)
+}
+
+// SpoolOptionsProvider is kind of options, which provides SpoolOptions.
+type SpoolOptionsProvider interface {
+	GetSpoolOptions() SpoolOptions
+}
+
+// GetSpoolOptions gets spool options from specified options.
+// If options do not provide any, zero SpoolOptions are returned.
+func GetSpoolOptions(options interface{}) (so SpoolOptions) {
+	if sopts, ok := options.(SpoolOptionsProvider); ok {
+		so = sopts.GetSpoolOptions()
+	}
+	return
+}
+
+// GetSpoolOptions makes SpoolOptions SpoolOptionsProvider, so they can be used as options.
+func (so SpoolOptions) GetSpoolOptions() SpoolOptions {
+	return so
+}
+
+func (so SpoolOptions) withDefaults() SpoolOptions {
+	if so.MemoryLimit == 0 {
+		so.MemoryLimit = defaultSpoolMemoryLimit
+	}
+	if so.NewFile == nil {
+		so.NewFile = newTempSpoolFile
+	}
+	return so
+}
+
+type tempSpoolFile struct {
+	*os.File
+}
+
+// Close closes and removes file.
+func (f tempSpoolFile) Close() (err error) {
+	err = f.File.Close()
+	rerr := os.Remove(f.File.Name())
+	if err == nil {
+		err = rerr
+	}
+	return
+}
+
+func newTempSpoolFile() (SpoolFile, error) {
+	f, err := ioutil.TempFile("", "uciph-spool")
+	if err != nil {
+		return nil, err
+	}
+	return tempSpoolFile{f}, nil
+}
+
+type spoolingStreamDecryptor struct {
+	Source  StreamDecryptor
+	Options SpoolOptions
+
+	Memory []byte
+	File   SpoolFile
+	Size   int64 // size of data in spool
+
+	Position int64
+	Verified bool
+
+	ErrorCache error
+}
+
+func (ssd *spoolingStreamDecryptor) write(data []byte) (err error) {
+	if ssd.File == nil && len(ssd.Memory)+len(data) > ssd.Options.MemoryLimit {
+		ssd.File, err = ssd.Options.NewFile()
+		if err != nil {
+			return
+		}
+		_, err = ssd.File.Write(ssd.Memory)
+		if err != nil {
+			return
+		}
+		wipe(ssd.Memory)
+		ssd.Memory = nil
+	}
+
+	if ssd.File != nil {
+		_, err = ssd.File.Write(data)
+	} else {
+		ssd.grow(len(data))
+		ssd.Memory = append(ssd.Memory, data...)
+	}
+	if err != nil {
+		return
+	}
+	ssd.Size += int64(len(data))
+	return
+}
+
+// grow makes sure that memory spool has room for n more bytes.
+// Buffer is reallocated here rather than by append, so old one can be wiped.
+func (ssd *spoolingStreamDecryptor) grow(n int) {
+	if len(ssd.Memory)+n <= cap(ssd.Memory) {
+		return
+	}
+	size := 2 * cap(ssd.Memory)
+	if size < len(ssd.Memory)+n {
+		size = len(ssd.Memory) + n
+	}
+	if size > ssd.Options.MemoryLimit {
+		size = ssd.Options.MemoryLimit
+	}
+	buf := make([]byte, len(ssd.Memory), size)
+	copy(buf, ssd.Memory)
+	wipe(ssd.Memory)
+	ssd.Memory = buf
+}
+
+func wipe(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
+
+// discard overwrites spooled data and releases spool.
+func (ssd *spoolingStreamDecryptor) discard() (err error) {
+	wipe(ssd.Memory[:cap(ssd.Memory)])
+	ssd.Memory = nil
+
+	if ssd.File != nil {
+		f := ssd.File
+		ssd.File = nil
+
+		_, err = f.Seek(0, io.SeekStart)
+		if err == nil {
+			var zeros [32 * 1024]byte
+			for left := ssd.Size; left > 0 && err == nil; {
+				sz := int64(len(zeros))
+				if left < sz {
+					sz = left
+				}
+				_, err = f.Write(zeros[:sz])
+				left -= sz
+			}
+		}
+		cerr := f.Close()
+		if err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// spool reads whole source into spool and makes sure that it has been verified.
+func (ssd *spoolingStreamDecryptor) spool() (err error) {
+	buf := make([]byte, 32*1024)
+	defer wipe(buf)
+
+	for {
+		var sz int
+		sz, err = ssd.Source.Read(buf)
+		if sz > 0 {
+			werr := ssd.write(buf[:sz])
+			if werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return
+		}
+	}
+
+	// Only close is guaranteed to detect truncation
+	err = ssd.Source.Close()
+	if err != nil {
+		return
+	}
+
+	if ssd.File != nil {
+		_, err = ssd.File.Seek(0, io.SeekStart)
+		if err != nil {
+			return
+		}
+	}
+	ssd.Verified = true
+	return
+}
+
+func (ssd *spoolingStreamDecryptor) Read(buf []byte) (sz int, err error) {
+	if ssd.ErrorCache != nil {
+		return 0, ssd.ErrorCache
+	}
+	defer func() {
+		if err != nil && err != io.EOF {
+			ssd.ErrorCache = err
+		}
+	}()
+
+	if !ssd.Verified {
+		err = ssd.spool()
+		if err != nil {
+			ssd.discard()
+			return
+		}
+	}
+
+	if len(buf) == 0 {
+		return
+	}
+	if ssd.Position >= ssd.Size {
+		return 0, io.EOF
+	}
+
+	if ssd.File != nil {
+		sz, err = ssd.File.Read(buf)
+		if err == io.EOF {
+			// spool has been truncated by someone else
+			err = io.ErrUnexpectedEOF
+		}
+	} else {
+		sz = copy(buf, ssd.Memory[ssd.Position:])
+	}
+	ssd.Position += int64(sz)
+	return
+}
+
+// Close discards spool. Source is closed as well, if it was not read yet.
+func (ssd *spoolingStreamDecryptor) Close() (err error) {
+	if !ssd.Verified && ssd.ErrorCache == nil {
+		err = ssd.Source.Close()
+	}
+	derr := ssd.discard()
+	if err == nil {
+		err = derr
+	}
+	if err == nil && ssd.ErrorCache != nil {
+		err = ssd.ErrorCache
+	}
+	return
+}
+
+// NewSpoolingStreamDecryptor wraps StreamDecryptor, so no data is returned until whole stream has been decrypted
+// and verified. First read decrypts whole stream into spool, which is kept in memory or in temporary file
+// as configured with SpoolOptions from options. Then data is returned from spool.
+//
+// Source is closed after it's read, so truncation is detected before any data is returned.
+// If any error occurs spool is overwritten with zeros and released. It's released on close as well.
+func NewSpoolingStreamDecryptor(sd StreamDecryptor, options interface{}) StreamDecryptor {
+	return &spoolingStreamDecryptor{
+		Source:  sd,
+		Options: GetSpoolOptions(options).withDefaults(),
+	}
+}
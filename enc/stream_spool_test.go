@@ -0,0 +1,132 @@
+package enc_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/enc"
+)
+
+type trackedSpoolFile struct {
+	*os.File
+	closed *bool
+}
+
+func (f trackedSpoolFile) Close() error {
+	*f.closed = true
+	err := f.File.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(f.File.Name())
+}
+
+func TestSpoolingStreamED(t *testing.T) {
+	for name, so := range map[string]enc.SpoolOptions{
+		"Memory": {MemoryLimit: 1 << 30},
+		"File":   {MemoryLimit: -1},
+		"Mixed":  {MemoryLimit: 1024},
+	} {
+		so := so
+		t.Run(name, func(t *testing.T) {
+			ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+				return enc.NewDefaultStreamEncryptor(enc.BlankEncryptor(), w)
+			}, func(r io.Reader) enc.StreamDecryptor {
+				return enc.NewSpoolingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), r), so)
+			})
+		})
+	}
+}
+
+func TestSpoolingStreamWithholdsTruncatedStream(t *testing.T) {
+	data := make([]byte, testChunkSize*3)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	encrypted, err := encryptStream(enc.BlankEncryptor(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cut final chunk off
+	encrypted = encrypted[:len(encrypted)-testChunkSize/2]
+
+	for name, limit := range map[string]int{
+		"Memory": 1 << 30,
+		"File":   1024,
+	} {
+		limit := limit
+		t.Run(name, func(t *testing.T) {
+			var created, closed bool
+			so := enc.SpoolOptions{
+				MemoryLimit: limit,
+				NewFile: func() (enc.SpoolFile, error) {
+					f := makeTempFile(t)
+					created = true
+					return trackedSpoolFile{File: f, closed: &closed}, nil
+				},
+			}
+			sd := enc.NewSpoolingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(encrypted)), so)
+
+			sz, err := sd.Read(make([]byte, 1024))
+			if err == nil || err == io.EOF {
+				t.Fatalf("Expected error, got %v", err)
+			}
+			if sz != 0 {
+				t.Fatalf("Expected no data, got %d bytes", sz)
+			}
+			if created && !closed {
+				t.Fatal("Expected spool file to be closed")
+			}
+			_, err = ioutil.ReadAll(sd)
+			if err == nil {
+				t.Fatal("Expected error to be cached")
+			}
+			if sd.Close() == nil {
+				t.Fatal("Expected error from close")
+			}
+		})
+	}
+}
+
+func TestSpoolingStreamRemovesFile(t *testing.T) {
+	data := make([]byte, 10*1024)
+	encrypted, err := encryptStream(enc.BlankEncryptor(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f *os.File
+	so := enc.SpoolOptions{
+		MemoryLimit: -1,
+		NewFile: func() (enc.SpoolFile, error) {
+			f = makeTempFile(t)
+			return f, nil
+		},
+	}
+	sd := enc.NewSpoolingStreamDecryptor(enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(encrypted)), so)
+	res, err := ioutil.ReadAll(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, data) {
+		t.Fatal("Data mismatch")
+	}
+	err = sd.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spooled, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range spooled {
+		if b != 0 {
+			t.Fatal("Expected spool file to be wiped")
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package enc
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/rand"
+	"golang.org/x/crypto/hkdf"
+)
+
+// streamSubkeyInfo is HKDF info used to derive stream keys.
+// Cipher is appended to it, so same master key yields different keys for different ciphers.
+const streamSubkeyInfo = "uciph/enc stream subkey"
+
+// DeriveStreamKey derives key of stream described by given header from master key using HKDF-SHA256.
+// Salt from header is used as HKDF salt.
+func DeriveStreamKey(masterKey []byte, h *StreamHeader) (key []byte, err error) {
+	if len(masterKey) == 0 {
+		err = uciph.ErrKeyInvalid
+		return
+	}
+	if len(h.Salt) != StreamHeaderSaltSize {
+		err = uciph.ErrStreamHeaderInvalid
+		return
+	}
+	keySize := h.Cipher.KeySize()
+	if keySize < 0 {
+		err = uciph.ErrCipherNotAllowed
+		return
+	}
+
+	info := append([]byte(streamSubkeyInfo), byte(h.Cipher))
+	key = make([]byte, keySize)
+	_, err = io.ReadFull(hkdf.New(sha256.New, masterKey, h.Salt, info), key)
+	return
+}
+
+// NewSubkeyStreamEncryptor creates StreamEncryptor, which encrypts stream with key derived from master key
+// and random salt, which is generated with RNG from options and stored in StreamHeader.
+//
+// Each stream has its own key, so nonce counter starts from zero for every stream and
+// NonceModeCounter is always used. StreamOptions are taken from options.
+func NewSubkeyStreamEncryptor(masterKey []byte, cipher CipherID, w io.Writer, options interface{}) (se StreamEncryptor, err error) {
+	h := StreamHeader{
+		Cipher:        cipher,
+		NonceMode:     NonceModeCounter,
+		StreamOptions: GetStreamOptions(options).withDefaults(),
+		Salt:          make([]byte, StreamHeaderSaltSize),
+	}
+	_, err = io.ReadFull(rand.GetRNG(options), h.Salt)
+	if err != nil {
+		return
+	}
+
+	key, err := DeriveStreamKey(masterKey, &h)
+	if err != nil {
+		return
+	}
+	ek, err := cipher.ParseEncKey(key)
+	if err != nil {
+		return
+	}
+	return newHeaderStreamEncryptor(ek, h, w, options)
+}
+
+// sameKeyForCiphers maps each of ciphers to same master key.
+func sameKeyForCiphers(key []byte, ciphers []CipherID) map[CipherID][]byte {
+	keys := make(map[CipherID][]byte, len(ciphers))
+	for _, c := range ciphers {
+		keys[c] = key
+	}
+	return keys
+}
+
+// NewSubkeyStreamDecryptor reads StreamHeader from reader and creates StreamDecryptor, which decrypts
+// stream created with NewSubkeyStreamEncryptor.
+//
+// Keys maps ciphers, which are allowed to be used, to master keys.
+// Cipher is read from header, so only ciphers present in keys are accepted. Other ones yield ErrCipherNotAllowed.
+// Streams built on top of subkey streams take list of allowed ciphers and pass it here.
+func NewSubkeyStreamDecryptor(masterKeys map[CipherID][]byte, r io.Reader, options interface{}) (sd StreamDecryptor, err error) {
+	h, err := ReadStreamHeader(r)
+	if err != nil {
+		return
+	}
+	if h.Salt == nil {
+		err = uciph.ErrStreamParamsMismatch
+		return
+	}
+
+	masterKey, ok := masterKeys[h.Cipher]
+	if !ok || masterKey == nil {
+		err = uciph.ErrCipherNotAllowed
+		return
+	}
+
+	key, err := DeriveStreamKey(masterKey, &h)
+	if err != nil {
+		return
+	}
+	dk, err := h.Cipher.ParseDecKey(key)
+	if err != nil {
+		return
+	}
+	return newHeaderStreamDecryptor(dk, h, r, options)
+}
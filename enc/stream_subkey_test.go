@@ -0,0 +1,144 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/copts"
+	"github.com/teawithsand/uciph/ctest"
+	"github.com/teawithsand/uciph/enc"
+)
+
+func TestSubkeyStreamED(t *testing.T) {
+	for _, id := range []enc.CipherID{
+		enc.CipherChaCha20Poly1305,
+		enc.CipherXChaCha20Poly1305,
+		enc.CipherAES128GCM,
+		enc.CipherAES256GCM,
+	} {
+		id := id
+		t.Run(id.String(), func(t *testing.T) {
+			masterKey, err := enc.ChaCha20Poly1305Keygen(nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := copts.Options{}.WithStreamOptions(enc.StreamOptions{
+				ChunkSize: 1000,
+			})
+			ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+				se, err := enc.NewSubkeyStreamEncryptor(masterKey, id, w, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return se
+			}, func(r io.Reader) enc.StreamDecryptor {
+				sd, err := enc.NewSubkeyStreamDecryptor(map[enc.CipherID][]byte{
+					id: masterKey,
+				}, r, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return sd
+			})
+		})
+	}
+}
+
+func TestSubkeyStreamUsesDistinctKeys(t *testing.T) {
+	masterKey, err := enc.ChaCha20Poly1305Keygen(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypt := func() []byte {
+		b := bytes.NewBuffer(nil)
+		se, err := enc.NewSubkeyStreamEncryptor(masterKey, enc.CipherChaCha20Poly1305, b, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = se.Write([]byte("Hello world"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = se.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+
+	first := encrypt()
+	second := encrypt()
+
+	h1, err := enc.ReadStreamHeader(bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := enc.ReadStreamHeader(bytes.NewReader(second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(h1.Salt, h2.Salt) {
+		t.Fatal("Salts of two streams are equal")
+	}
+
+	// Both streams start with nonce counter equal to zero, so bodies would be equal with same key.
+	k1, err := enc.DeriveStreamKey(masterKey, &h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := enc.DeriveStreamKey(masterKey, &h2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(k1, k2) {
+		t.Fatal("Keys of two streams are equal")
+	}
+}
+
+func TestSubkeyStreamRejectsPlainHeader(t *testing.T) {
+	ek, dk := makeChaCha20Keys(t)
+	masterKey, err := enc.ChaCha20Poly1305Keygen(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewHeaderStreamEncryptor(ek, enc.CipherChaCha20Poly1305, b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = enc.NewSubkeyStreamDecryptor(map[enc.CipherID][]byte{
+		enc.CipherChaCha20Poly1305: masterKey,
+	}, bytes.NewReader(b.Bytes()), nil)
+	if !errors.Is(err, uciph.ErrStreamParamsMismatch) {
+		t.Fatalf("Expected ErrStreamParamsMismatch, got %v", err)
+	}
+
+	b = bytes.NewBuffer(nil)
+	se, err = enc.NewSubkeyStreamEncryptor(masterKey, enc.CipherChaCha20Poly1305, b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := enc.NewHeaderStreamDecryptor(map[enc.CipherID]enc.DecKey{
+		enc.CipherChaCha20Poly1305: dk,
+	}, bytes.NewReader(b.Bytes()), nil)
+	if err == nil {
+		_, err = ioutil.ReadAll(sd)
+	}
+	if !errors.Is(err, uciph.ErrStreamParamsMismatch) {
+		t.Fatalf("Expected ErrStreamParamsMismatch, got %v", err)
+	}
+}
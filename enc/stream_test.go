@@ -1,9 +1,14 @@
 package enc_test
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"io"
+	"io/ioutil"
 	"testing"
 
+	"github.com/teawithsand/uciph"
 	"github.com/teawithsand/uciph/copts"
 	"github.com/teawithsand/uciph/ctest"
 	"github.com/teawithsand/uciph/enc"
@@ -86,3 +91,276 @@ func TestStreamED(t *testing.T) {
 }
 
 // TODO(teawithsand): benchmarks for stream encryptor/decryptor
+
+func TestStreamDetectsTruncation(t *testing.T) {
+	data := make([]byte, testChunkSize*2+1)
+	encrypted, err := encryptStream(enc.BlankEncryptor(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cut terminator off
+	sd := enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(encrypted[:len(encrypted)-1]))
+	_, err = ioutil.ReadAll(sd)
+	if !errors.Is(err, uciph.ErrStreamTruncated) {
+		t.Fatalf("Expected ErrStreamTruncated, got %v", err)
+	}
+}
+
+func TestStreamDetectsTruncationAtChunkBoundary(t *testing.T) {
+	data := make([]byte, testChunkSize*2+1)
+	encrypted, err := encryptStream(enc.BlankEncryptor(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep first two chunks and append terminator, so stream looks like it was properly ended.
+	var lengthBuffer [binary.MaxVarintLen64]byte
+	frameSize := binary.PutUvarint(lengthBuffer[:], 1+1+testChunkSize) + 1 + 1 + testChunkSize
+	truncated := append(append([]byte{}, encrypted[:frameSize*2]...), 0)
+
+	sd := enc.NewDefaultStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(truncated))
+	_, err = ioutil.ReadAll(sd)
+	if !errors.Is(err, uciph.ErrStreamTruncated) {
+		t.Fatalf("Expected ErrStreamTruncated, got %v", err)
+	}
+
+	_, err = enc.NewSeekableStreamDecryptor(enc.BlankDecryptor(), bytes.NewReader(truncated), int64(len(truncated)), nil)
+	if !errors.Is(err, uciph.ErrStreamTruncated) {
+		t.Fatalf("Expected ErrStreamTruncated from seekable decryptor, got %v", err)
+	}
+}
+
+func TestStreamEDWithOptions(t *testing.T) {
+	for name, so := range map[string]enc.StreamOptions{
+		"SmallChunks": {
+			ChunkSize: 1000,
+		},
+		"FixedSizeEncodings": {
+			ChunkSize:            64 * 1024,
+			ChunkLengthEncoding:  enc.Byte4,
+			ChunkCounterEncoding: enc.Byte8,
+		},
+		"NoChunkLengths": {
+			ChunkSize:      4096,
+			NoChunkLengths: true,
+		},
+	} {
+		opts := copts.Options{}.WithStreamOptions(so).WithNonceMode(enc.NonceModeCounter)
+		t.Run(name, func(t *testing.T) {
+			e, d := makeChaCha20ED(t, enc.NonceModeCounter)
+			ctest.DoTestStreamED(t, func(w io.Writer) enc.StreamEncryptor {
+				se, err := enc.NewDefaultStreamEncryptorWithOptions(e, w, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return se
+			}, func(r io.Reader) enc.StreamDecryptor {
+				sd, err := enc.NewDefaultStreamDecryptorWithOptions(d, r, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return sd
+			})
+		})
+	}
+}
+
+func TestStreamOptionsValidate(t *testing.T) {
+	err := enc.StreamOptions{
+		ChunkLengthEncoding: enc.Byte1,
+	}.Validate()
+	if !errors.Is(err, uciph.ErrStreamOptionsInvalid) {
+		t.Errorf("Expected ErrStreamOptionsInvalid, got %v", err)
+	}
+
+	err = enc.StreamOptions{
+		ChunkSize:           128,
+		MaxChunkSize:        255,
+		ChunkLengthEncoding: enc.Byte1,
+	}.Validate()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStreamDetectsParamsMismatch(t *testing.T) {
+	data := make([]byte, 10*1024)
+
+	b := bytes.NewBuffer(nil)
+	se, err := enc.NewDefaultStreamEncryptorWithOptions(enc.BlankEncryptor(), b, copts.Options{}.WithStreamOptions(enc.StreamOptions{
+		ChunkSize: 1024,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := enc.NewDefaultStreamDecryptorWithOptions(enc.BlankDecryptor(), b, copts.Options{}.WithStreamOptions(enc.StreamOptions{
+		ChunkSize: 2048,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(sd)
+	if !errors.Is(err, uciph.ErrStreamParamsMismatch) {
+		t.Fatalf("Expected ErrStreamParamsMismatch, got %v", err)
+	}
+}
+
+type syncBuffer struct {
+	bytes.Buffer
+	Synced int
+}
+
+func (b *syncBuffer) Sync() error {
+	b.Synced++
+	return nil
+}
+
+func TestStreamFlush(t *testing.T) {
+	const chunkSize = 100
+	opts := copts.Options{}.
+		WithNonceMode(enc.NonceModeCounter).
+		WithStreamOptions(enc.StreamOptions{ChunkSize: chunkSize, ChunkLengthEncoding: enc.Byte2})
+	ek, dk := makeChaCha20Keys(t)
+	e, err := ek(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &syncBuffer{}
+	se, err := enc.NewDefaultStreamEncryptorWithOptions(e, b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fse := se.(enc.FlushableStreamEncryptor)
+
+	var data []byte
+	var flushedSizes []int
+	for i, sz := range []int{10, 150, 0, 90, 100} {
+		part := bytes.Repeat([]byte{byte(i + 1)}, sz)
+		data = append(data, part...)
+		_, err = fse.Write(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = fse.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		flushedSizes = append(flushedSizes, b.Len())
+	}
+	if b.Synced != 5 {
+		t.Fatalf("Sink was synced %d times", b.Synced)
+	}
+	// flushed data is written, even though encryptor was not closed
+	if b.Len() == 0 || flushedSizes[0] == 0 || flushedSizes[2] != flushedSizes[1] {
+		t.Fatalf("Invalid sizes of flushed data: %v", flushedSizes)
+	}
+	err = fse.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := b.Bytes()
+
+	decrypt := func(encrypted []byte, parallel bool) (res []byte, err error) {
+		d, err := dk(opts)
+		if err != nil {
+			return
+		}
+		var sd enc.StreamDecryptor
+		if parallel {
+			sd, err = enc.NewParallelStreamDecryptor(d, bytes.NewReader(encrypted), opts)
+		} else {
+			sd, err = enc.NewDefaultStreamDecryptorWithOptions(d, bytes.NewReader(encrypted), opts)
+		}
+		if err != nil {
+			return
+		}
+		res, err = ioutil.ReadAll(sd)
+		if err != nil {
+			return
+		}
+		err = sd.Close()
+		return
+	}
+
+	for _, parallel := range []bool{false, true} {
+		res, err := decrypt(encrypted, parallel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res, data) {
+			t.Fatal("Decrypted data differs")
+		}
+	}
+
+	// stream cut right after flushed chunk
+	_, err = decrypt(encrypted[:flushedSizes[0]], false)
+	if !errors.Is(err, uciph.ErrStreamTruncated) {
+		t.Fatalf("Expected ErrStreamTruncated, got %v", err)
+	}
+
+	// first two flushed chunks swapped: 10 bytes chunk and 50 bytes chunk after full one
+	frame := func(dataSize int) int {
+		return 2 + 1 + 1 + dataSize + 16
+	}
+	first := encrypted[:frame(10)]
+	rest := encrypted[frame(10):]
+	second := rest[frame(100) : frame(100)+frame(50)]
+	reordered := append(append(append(append([]byte{}, second...), rest[:frame(100)]...), first...), rest[frame(100)+frame(50):]...)
+	_, err = decrypt(reordered, false)
+	if err == nil {
+		t.Fatal("Expected error for reordered flushed chunks")
+	}
+}
+
+func TestStreamFlushRequiresChunkLengths(t *testing.T) {
+	se, err := enc.NewDefaultStreamEncryptorWithOptions(enc.BlankEncryptor(), ioutil.Discard, enc.StreamOptions{NoChunkLengths: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write([]byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.(enc.FlushableStreamEncryptor).Flush()
+	if err != uciph.ErrStreamOptionsInvalid {
+		t.Fatalf("Expected ErrStreamOptionsInvalid, got %v", err)
+	}
+}
+
+func TestSeekableStreamRejectsFlushedChunks(t *testing.T) {
+	e, d := makeChaCha20ED(t, enc.NonceModeCounter)
+	b := bytes.NewBuffer(nil)
+	se := enc.NewDefaultStreamEncryptor(e, b)
+	_, err := se.Write([]byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.(enc.FlushableStreamEncryptor).Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = se.Write([]byte{4, 5, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = se.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = enc.NewSeekableStreamDecryptor(d, bytes.NewReader(b.Bytes()), int64(b.Len()), nil)
+	if err == nil {
+		t.Fatal("Expected error for stream with flushed chunks")
+	}
+}
@@ -45,3 +45,52 @@ var ErrStreamTruncated = errors.New("uciph: This stream logically should have mo
 
 // ErrChunkTooBig is returend when chunk is too big
 var ErrChunkTooBig = errors.New("uciph: This stream contains too long chunks and can't be processed")
+
+// ErrRandomAccessNotSupported is returned when random access to stream is requested
+// but given decryptor is not able to decrypt chunks in random order.
+var ErrRandomAccessNotSupported = errors.New("uciph: Given decryptor is not able to decrypt chunks in random order")
+
+// ErrStreamOptionsInvalid is returned when given stream options can't be used to create valid stream.
+var ErrStreamOptionsInvalid = errors.New("uciph: Given stream options are invalid")
+
+// ErrStreamParamsMismatch is returned when StreamDecryptor finds out that stream has been created
+// with different parameters than ones it was given.
+var ErrStreamParamsMismatch = errors.New("uciph: Stream has been created with different parameters than given ones")
+
+// ErrStreamHeaderInvalid is returned when stream header is corrupted or has unsupported version.
+var ErrStreamHeaderInvalid = errors.New("uciph: Stream header is invalid or has unsupported version")
+
+// ErrCipherNotAllowed is returned when data has been encrypted with cipher, which is not allowed
+// to decrypt it.
+var ErrCipherNotAllowed = errors.New("uciph: Data has been encrypted with cipher, which is not allowed")
+
+// ErrParallelNotSupported is returned when parallel processing of stream is requested
+// but given encryptor or decryptor is not able to process chunks independently.
+var ErrParallelNotSupported = errors.New("uciph: Given encryptor or decryptor is not able to process chunks in parallel")
+
+// ErrMetadataInvalid is returned when encrypted container has metadata, which is corrupted or has unsupported format.
+var ErrMetadataInvalid = errors.New("uciph: Container metadata is invalid or has unsupported version")
+
+// ErrNoMatchingRecipient is returned when none of recipients of multi recipient stream can be decrypted with given key.
+var ErrNoMatchingRecipient = errors.New("uciph: Given key does not match any of recipients")
+
+// ErrResumeNotSupported is returned when stream encryption is requested to be resumed
+// but given encryptor is not able to encrypt chunk given its index.
+var ErrResumeNotSupported = errors.New("uciph: Given encryptor is not able to resume stream encryption")
+
+// ErrNonceReuse is returned when requested operation would encrypt different data with nonce, which was already used.
+var ErrNonceReuse = errors.New("uciph: Operation would reuse nonce")
+
+// ErrArchiveInvalid is returned when encrypted archive is corrupted or has unsupported version.
+var ErrArchiveInvalid = errors.New("uciph: Archive is invalid or has unsupported version")
+
+// ErrArchivePathInvalid is returned when archive entry path is not clean relative slash separated path
+// or when it's already used by other entry.
+var ErrArchivePathInvalid = errors.New("uciph: Archive entry path is invalid")
+
+// ErrDecompressionLimit is returned when decompressed data would exceed maximal allowed compression ratio.
+var ErrDecompressionLimit = errors.New("uciph: Decompressed data exceeds allowed compression ratio")
+
+// ErrReplayDetected is returned when message has been already decrypted
+// or it's too old to tell whether it has been.
+var ErrReplayDetected = errors.New("uciph: Message has been replayed or is too old")
@@ -0,0 +1,63 @@
+package pad
+
+import "math/bits"
+
+// Bucket returns size, which message of given size should be padded to.
+// Returned size is never less than given one.
+//
+// Buckets make sizes of many messages equal, so size of ciphertext leaks less about size of plaintext.
+type Bucket func(size uint64) uint64
+
+// PadmeBucket pads size using Padmé scheme from PURBs paper.
+// It leaks O(log log size) bits of information about size and adds at most about 12% overhead.
+func PadmeBucket(size uint64) uint64 {
+	if size < 2 {
+		return size
+	}
+	e := bits.Len64(size) - 1
+	s := bits.Len64(uint64(e))
+	lastBits := e - s
+	if lastBits <= 0 {
+		return size
+	}
+	mask := uint64(1)<<uint(lastBits) - 1
+	padded := (size + mask) &^ mask
+	if padded < size {
+		// overflow
+		return size
+	}
+	return padded
+}
+
+// PowerOfTwoBucket pads size to next power of two.
+// It leaks only O(log size) bits of information about size but may add up to 100% overhead.
+func PowerOfTwoBucket(size uint64) uint64 {
+	if size <= 1 {
+		return size
+	}
+	shift := uint(bits.Len64(size - 1))
+	if shift >= 64 {
+		// overflow
+		return size
+	}
+	return uint64(1) << shift
+}
+
+// BlockBucket creates Bucket, which pads size to multiple of blockSize.
+func BlockBucket(blockSize uint64) Bucket {
+	if blockSize == 0 {
+		panic("uciph/pad: Block size must not be zero")
+	}
+	return func(size uint64) uint64 {
+		rem := size % blockSize
+		if rem == 0 {
+			return size
+		}
+		padded := size + blockSize - rem
+		if padded < size {
+			// overflow
+			return size
+		}
+		return padded
+	}
+}
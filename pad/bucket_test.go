@@ -0,0 +1,45 @@
+package pad_test
+
+import (
+	"testing"
+
+	"github.com/teawithsand/uciph/pad"
+)
+
+func TestBuckets(t *testing.T) {
+	for _, tc := range []struct {
+		Name     string
+		Bucket   pad.Bucket
+		Size     uint64
+		Expected uint64
+	}{
+		{"Padme_0", pad.PadmeBucket, 0, 0},
+		{"Padme_1", pad.PadmeBucket, 1, 1},
+		{"Padme_9", pad.PadmeBucket, 9, 10},
+		{"Padme_100", pad.PadmeBucket, 100, 104},
+		{"Padme_1000", pad.PadmeBucket, 1000, 1024},
+		{"Padme_1024", pad.PadmeBucket, 1024, 1024},
+		{"Padme_1025", pad.PadmeBucket, 1025, 1088},
+		{"PowerOfTwo_1", pad.PowerOfTwoBucket, 1, 1},
+		{"PowerOfTwo_3", pad.PowerOfTwoBucket, 3, 4},
+		{"PowerOfTwo_1024", pad.PowerOfTwoBucket, 1024, 1024},
+		{"PowerOfTwo_1025", pad.PowerOfTwoBucket, 1025, 2048},
+		{"Block_1", pad.BlockBucket(256), 1, 256},
+		{"Block_256", pad.BlockBucket(256), 256, 256},
+		{"Block_257", pad.BlockBucket(256), 257, 512},
+	} {
+		res := tc.Bucket(tc.Size)
+		if res != tc.Expected {
+			t.Errorf("%s: expected %d got %d", tc.Name, tc.Expected, res)
+		}
+	}
+}
+
+func TestPadmeBucketOverheadIsBounded(t *testing.T) {
+	for size := uint64(1); size < 1<<16; size++ {
+		res := pad.PadmeBucket(size)
+		if res < size || float64(res-size) > float64(size)*0.12 {
+			t.Fatalf("Invalid padded size of %d: %d", size, res)
+		}
+	}
+}
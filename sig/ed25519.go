@@ -30,6 +30,7 @@ func Ed25519Keygen(options interface{}, dst *GeneratedKeys) (err error) {
 }
 
 // ParseEd25519SigKey parses signing key for Ed25519 signing algorithm.
+// Unless options provide SigningHasherOptions, signer buffers all data written to it in memory.
 func ParseEd25519SigKey(data []byte) (SigKey, error) {
 	var sk ed25519SigKey
 	if len(sk) != len(data) {
@@ -63,6 +64,7 @@ func ParseEd25519SigKey(data []byte) (SigKey, error) {
 }
 
 // ParseEd25519VerKey parses signing key for Ed25519 signing algorithm.
+// Unless options provide SigningHasherOptions, verifier buffers all data written to it in memory.
 func ParseEd25519VerKey(data []byte) (VerKey, error) {
 	var vk ed25519VerKey
 	if len(vk) != len(data) {
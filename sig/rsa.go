@@ -69,7 +69,7 @@ func NewRSASigKeyParser(size RSAKeySize) (SigKeyParser, error) {
 		if err != nil {
 			return nil, err
 		}
-		if secKey.Size() != int(size) {
+		if secKey.Size()*8 != int(size) {
 			return nil, uciph.ErrInvalidKeySize
 		}
 		// Should primes be rabin-miller checked here?
@@ -140,7 +140,7 @@ func NewRSAVerKeyParser(size RSAKeySize) (VerKeyParser, error) {
 		if err != nil {
 			return nil, err
 		}
-		if pubKey.Size() != int(size) {
+		if pubKey.Size()*8 != int(size) {
 			return nil, uciph.ErrInvalidKeySize
 		}
 		// Should primes be rabin-miller checked here?
@@ -0,0 +1,60 @@
+package sig_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/teawithsand/uciph"
+	"github.com/teawithsand/uciph/sig"
+)
+
+func TestRSAKeySize(t *testing.T) {
+	gk := &sig.GeneratedKeys{}
+	err := sig.RSAKeygen(nil, sig.RSA1024, gk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sk, err := sig.ParseRSASigKey(gk.SigningKey, sig.RSA1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vk, err := sig.ParseRSAVerKey(gk.VerifyingKey, sig.RSA1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := sk(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Write([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign, err := s.Finalize(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := vk(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = v.Write([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.Verify(sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sig.ParseRSASigKey(gk.SigningKey, sig.RSA2048)
+	if !errors.Is(err, uciph.ErrInvalidKeySize) {
+		t.Fatalf("Expected ErrInvalidKeySize, got %v", err)
+	}
+	_, err = sig.ParseRSAVerKey(gk.VerifyingKey, sig.RSA2048)
+	if !errors.Is(err, uciph.ErrInvalidKeySize) {
+		t.Fatalf("Expected ErrInvalidKeySize, got %v", err)
+	}
+}